@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+	"gopkg.in/yaml.v2"
+)
+
+// Reads a JSON or YAML boot config file into an inmem tree
+// The format is picked by file extension, defaulting to JSON
+// Expects the same layout as the ROM kv, e.g. services/<name>/path
+func loadBootConfig(filePath string) (*inmem.Folder, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &doc)
+	default:
+		err = json.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %v", filePath, err)
+	}
+
+	entry, err := buildCfgEntry("cfg", doc)
+	if err != nil {
+		return nil, err
+	}
+	cfg, ok := entry.(*inmem.Folder)
+	if !ok {
+		return nil, fmt.Errorf("%s should contain a map at the top level", filePath)
+	}
+
+	if services, ok := cfg.Fetch("services"); ok {
+		if _, ok := services.(base.Folder); !ok {
+			return nil, fmt.Errorf("%s has a services key that isn't a map", filePath)
+		}
+	}
+	return cfg, nil
+}
+
+// Converts decoded JSON/YAML values into entries
+// Maps and lists become Folders, everything else becomes a String
+func buildCfgEntry(name string, value interface{}) (base.Entry, error) {
+	switch value := value.(type) {
+
+	case map[string]interface{}:
+		folder := inmem.NewFolder(name)
+		for key, child := range value {
+			entry, err := buildCfgEntry(key, child)
+			if err != nil {
+				return nil, err
+			}
+			folder.Put(key, entry)
+		}
+		return folder, nil
+
+	case map[interface{}]interface{}:
+		// yaml.v2 doesn't force string keys
+		folder := inmem.NewFolder(name)
+		for rawKey, child := range value {
+			key := fmt.Sprint(rawKey)
+			entry, err := buildCfgEntry(key, child)
+			if err != nil {
+				return nil, err
+			}
+			folder.Put(key, entry)
+		}
+		return folder, nil
+
+	case []interface{}:
+		// lists are folders named by index
+		folder := inmem.NewFolder(name)
+		for idx, child := range value {
+			key := strconv.Itoa(idx + 1)
+			entry, err := buildCfgEntry(key, child)
+			if err != nil {
+				return nil, err
+			}
+			folder.Put(key, entry)
+		}
+		return folder, nil
+
+	case string:
+		return inmem.NewString(name, value), nil
+
+	case bool:
+		if value {
+			return inmem.NewString(name, "yes"), nil
+		}
+		return inmem.NewString(name, "no"), nil
+
+	case float64:
+		return inmem.NewString(name, strconv.FormatFloat(value, 'f', -1, 64)), nil
+
+	case int:
+		return inmem.NewString(name, strconv.Itoa(value)), nil
+
+	case int64:
+		// yaml.v2 uses these for values too big for an int
+		return inmem.NewString(name, strconv.FormatInt(value, 10)), nil
+
+	case uint64:
+		return inmem.NewString(name, strconv.FormatUint(value, 10)), nil
+
+	case nil:
+		return inmem.NewString(name, ""), nil
+
+	default:
+		return nil, fmt.Errorf("boot config key %s has unsupported type %T", name, value)
+	}
+}
+
+// How long to wait on a storage driver before falling back to the ROM
+const nvramTimeout = 10 * time.Second
+
+// Bind rom keyval tree to /boot/cfg
+func bindRomConfig(ctx base.Context) {
+	kv, ok := ctx.GetFolder("/n/rom/kv")
+	if !ok {
+		panic("ROM KV not found. That shouldn't happen.")
+	}
+	ctx.Put("/boot/cfg", kv)
+}
+
+// Whether nvramInput knows how to set up the driver
+func isNvramDriver(driver string) bool {
+	switch driver {
+	case "consul", "redis-ns":
+		return true
+	}
+	return false
+}
+
+// Builds the clone input for an nvram driver from the command line flags
+func nvramInput(driver, consulUri, redisAddress, subPath string) (input base.Entry, cfgPath string) {
+	switch driver {
+
+	case "consul":
+		input = inmem.NewString("consul-uri", consulUri)
+		cfgPath = "kv"
+
+	case "redis-ns":
+		input = inmem.NewFolderOf("redis-ns",
+			inmem.NewString("address", redisAddress),
+		).Freeze()
+		cfgPath = "boot-cfg"
+	}
+
+	if subPath != "" {
+		cfgPath = subPath
+	}
+	return
+}
+
+// Clones a storage driver from /rom/drv, mounts it at /n/nvram,
+// and binds a subtree of it to /boot/cfg
+// Returns false if the store couldn't be reached or lacks services
+func bootFromNvram(ctx base.Context, driver string, input base.Entry, cfgPath string) (ok bool) {
+	clone, ok := ctx.GetFunction(path.Join("/rom/drv", driver, "invoke"))
+	if !ok {
+		log.Println("boot: nvram driver", driver, "doesn't exist")
+		return false
+	}
+
+	type nvramResult struct {
+		root base.Entry
+		cfg  base.Folder
+	}
+	results := make(chan nvramResult, 1)
+
+	// drivers tend to panic or block when their store is down
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("boot: nvram driver", driver, "crashed:", r)
+				results <- nvramResult{}
+			}
+		}()
+
+		root := clone.Invoke(ctx, input)
+		cfg, ok := walkFolder(root, cfgPath)
+		if !ok {
+			log.Println("boot: nvram", driver, "doesn't have a folder at", cfgPath)
+			results <- nvramResult{}
+			return
+		}
+
+		// make sure there's actually something to boot
+		if services, ok := cfg.Fetch("services"); !ok {
+			log.Println("boot: nvram", driver, "has no services in", cfgPath)
+			results <- nvramResult{}
+			return
+		} else if _, ok := services.(base.Folder); !ok {
+			log.Println("boot: nvram", driver, "services in", cfgPath, "wasn't a Folder")
+			results <- nvramResult{}
+			return
+		}
+
+		results <- nvramResult{root, cfg}
+	}()
+
+	select {
+	case result := <-results:
+		if result.cfg == nil {
+			return false
+		}
+		ctx.Put("/n/nvram", result.root)
+		ctx.Put("/boot/cfg", result.cfg)
+		log.Println("Booting from", driver, "nvram at", cfgPath)
+		return true
+
+	case <-time.After(nvramTimeout):
+		log.Println("boot: nvram driver", driver, "timed out after", nvramTimeout)
+		return false
+	}
+}
+
+// Fetches a slash-separated path of Folders, starting at root
+func walkFolder(root base.Entry, subPath string) (folder base.Folder, ok bool) {
+	folder, ok = root.(base.Folder)
+	if !ok {
+		return
+	}
+
+	for _, name := range strings.Split(subPath, "/") {
+		if name == "" {
+			continue
+		}
+		var entry base.Entry
+		if entry, ok = folder.Fetch(name); !ok {
+			return
+		}
+		if folder, ok = entry.(base.Folder); !ok {
+			return
+		}
+	}
+	return
+}
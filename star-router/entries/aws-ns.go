@@ -159,6 +159,13 @@ func (e *awsNsFolder) Fetch(name string) (entry base.Entry, ok bool) {
 		}
 		return inmem.NewFile(name, data), true
 
+	case "Link":
+		var target string
+		if att, ok := result.Item["Value"]; ok {
+			target = *att.S
+		}
+		return inmem.NewLink(name, target), true
+
 	case "Folder":
 		return &awsNsFolder{
 			ns: e.ns,
@@ -222,6 +229,10 @@ func (e *awsNsFolder) Put(name string, entry base.Entry) (ok bool) {
 		// easy enough
 		params.Item["Type"] = &dynamodb.AttributeValue{S: aws.String("Folder")}
 
+	case base.Link:
+		params.Item["Type"] = &dynamodb.AttributeValue{S: aws.String("Link")}
+		params.Item["Value"] = &dynamodb.AttributeValue{S: aws.String(entry.Target())}
+
 	case base.String:
 		params.Item["Type"] = &dynamodb.AttributeValue{S: aws.String("String")}
 		if value := entry.Get(); len(value) > 0 {
@@ -247,5 +258,23 @@ func (e *awsNsFolder) Put(name string, entry base.Entry) (ok bool) {
 		log.Println("dynamodb put error:", err)
 		return false
 	}
-	return true
+
+	// store the folder's contents too, like the other backends do
+	ok = true
+	if folder, isFolder := entry.(base.Folder); isFolder {
+		dest := &awsNsFolder{
+			ns: e.ns,
+			id: path.Join(e.id, name),
+		}
+		for _, child := range folder.Children() {
+			childEnt, found := folder.Fetch(child)
+			if !found {
+				log.Println("dynamodb: Failed to get child", child, "of", name)
+				ok = false
+			} else if !dest.Put(child, childEnt) {
+				ok = false
+			}
+		}
+	}
+	return
 }
@@ -88,6 +88,10 @@ func (e *consulRoot) Put(name string, entry base.Entry) (ok bool) {
 	return false
 }
 
+// Marks keys that hold a link target instead of a string
+// Consul keeps flags alongside each key for clients to use
+const consulLinkFlag uint64 = 0x1
+
 // Directory/String tree backed by consul kv
 // This struct only represents a folder
 // inmem strings are used to handle keys
@@ -148,6 +152,9 @@ func (e *consulKV) Fetch(name string) (entry base.Entry, ok bool) {
 		return nil, false
 	}
 	if pair != nil {
+		if pair.Flags&consulLinkFlag != 0 {
+			return inmem.NewLink(name, string(pair.Value)), true
+		}
 		return inmem.NewString(name, string(pair.Value)), true
 	}
 
@@ -184,6 +191,27 @@ func (e *consulKV) Put(name string, entry base.Entry) (ok bool) {
 
 	switch entry := entry.(type) {
 
+	case nil:
+		// remove the key, and the folder by that name if there is one
+		if _, err := e.kv.Delete(prefix+name, nil); err != nil {
+			panic(err)
+		}
+		if _, err := e.kv.DeleteTree(prefix+name+"/", nil); err != nil {
+			panic(err)
+		}
+		ok = true
+
+	case base.Link:
+		_, err := e.kv.Put(&api.KVPair{
+			Key:   prefix + name,
+			Value: []byte(entry.Target()),
+			Flags: consulLinkFlag,
+		}, nil)
+		if err != nil {
+			panic(err)
+		}
+		ok = true
+
 	case base.String:
 		// TODO: make sure not already a folder?
 		_, err := e.kv.Put(&api.KVPair{
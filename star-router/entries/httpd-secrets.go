@@ -0,0 +1,61 @@
+package entries
+
+import (
+	"strings"
+
+	"github.com/stardustapp/core/base"
+)
+
+// Names that httpd won't read or write, wherever they turn up
+// httpd doesn't ask who's calling, so anything it serves is public
+// The export driver doesn't know about these, so keep its input-path clear of them
+var httpdSecretNames = map[string]bool{
+	"host-key":        true, // ray-ssh's private key
+	"password-hash":   true, // ray-ssh logins
+	"authorized-keys": true, // decides who gets a ray-ssh shell
+}
+
+// Whether any name along the path is a secret
+func isHttpdSecret(p string) bool {
+	for _, name := range strings.Split(p, "/") {
+		if httpdSecretNames[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// A folder that acts like its secrets aren't there
+// Subfolders get wrapped as they're fetched, so the whole tree is covered
+type httpdSecretless struct {
+	base.Folder
+}
+
+func (f httpdSecretless) Children() []string {
+	names := f.Folder.Children()
+	visible := make([]string, 0, len(names))
+	for _, name := range names {
+		if !httpdSecretNames[name] {
+			visible = append(visible, name)
+		}
+	}
+	return visible
+}
+
+func (f httpdSecretless) Fetch(name string) (base.Entry, bool) {
+	if httpdSecretNames[name] {
+		return nil, false
+	}
+	entry, ok := f.Folder.Fetch(name)
+	if folder, isFolder := entry.(base.Folder); ok && isFolder {
+		return httpdSecretless{folder}, true
+	}
+	return entry, ok
+}
+
+func (f httpdSecretless) Put(name string, entry base.Entry) bool {
+	if httpdSecretNames[name] {
+		return false
+	}
+	return f.Folder.Put(name, entry)
+}
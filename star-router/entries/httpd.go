@@ -2,6 +2,7 @@ package entries
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/danopia/stardust/star-router/render"
 	"github.com/stardustapp/core/base"
 	"github.com/stardustapp/core/inmem"
 )
@@ -27,37 +29,114 @@ func getHttpdDriver() base.Folder {
 // Function that creates a new HTTP server when invoked
 func httpdFunc(ctx base.Context, input base.Entry) (output base.Entry) {
 	svc := &httpd{
-		root: input.(base.Folder),
-		ctx:  ctx,
+		root:   input.(base.Folder),
+		ctx:    ctx,
+		host:   fmt.Sprint("0.0.0.0:", 9234),
+		exited: make(chan error, 1),
+		closed: make(chan struct{}),
 		//rayFunc:   input.(base.Function), // TODO
 		//tmpFolder: inmem.NewFolder("ray-ssh"),
 	}
 
-	http.Handle("/~~/", svc)
-	go svc.listen()
+	// Our own mux so that restarting doesn't register twice
+	// Other drivers still hang handlers off of the default mux
+	mux := http.NewServeMux()
+	mux.Handle("/~~/", svc)
+	mux.Handle("/", http.DefaultServeMux)
+	svc.server = &http.Server{
+		Addr:    svc.host,
+		Handler: mux,
+	}
 
-	return nil // svc.tmpFolder
+	go svc.listen()
+	return svc
 }
 
-// Context for a running SSH server
+// Context for a running HTTP server
 type httpd struct {
-	ctx  base.Context
-	root base.Folder
+	ctx    base.Context
+	root   base.Folder
+	host   string
+	server *http.Server
+	exited chan error
+	closed chan struct{} // once the listener is gone
 	//rayFunc   base.Function
 	//tmpFolder base.Folder
 }
 
+var _ base.Folder = (*httpd)(nil)
+
 func (e *httpd) listen() {
-	host := fmt.Sprint("0.0.0.0:", 9234)
-	log.Printf("Listening on %s...", host)
-	if err := http.ListenAndServe(host, nil); err != nil {
+	log.Printf("Listening on %s...", e.host)
+	err := e.server.ListenAndServe()
+	close(e.closed)
+	if err == http.ErrServerClosed {
+		// we were asked to stop
+		err = nil
+	} else if err != nil {
 		log.Println("ListenAndServe: ", err)
 	}
+	e.exited <- err
+}
+
+// Lets init know when the listener goes away
+func (e *httpd) Exited() <-chan error {
+	return e.exited
+}
+
+// Stops listening, giving open requests a few seconds to finish
+// Those are left to finish in the background, since one of them
+// may well be the PUT that asked for the stop and can't finish first
+func (e *httpd) Stop() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := e.server.Shutdown(ctx); err != nil {
+			log.Println("httpd: Shutdown:", err)
+		}
+	}()
+
+	// the port is free again once ListenAndServe returns
+	<-e.closed
+}
+
+func (e *httpd) Name() string {
+	return "httpd"
+}
+
+func (e *httpd) Children() []string {
+	return []string{"listen-address"}
+}
+
+func (e *httpd) Fetch(name string) (entry base.Entry, ok bool) {
+	switch name {
+
+	case "listen-address":
+		return inmem.NewString(name, e.host), true
+
+	default:
+		return
+	}
+}
+
+func (e *httpd) Put(name string, entry base.Entry) (ok bool) {
+	return false
 }
 
 func (e *httpd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// r.Method, r.URL, r.Proto, r.Header, r.Body, r.Host, r.Form, r.RemoteAddr
 
+	// every method takes paths from the URI or these headers
+	requestPath, _ := url.PathUnescape(r.RequestURI)
+	for _, p := range []string{requestPath, r.Header.Get("X-Sd-Input"), r.Header.Get("X-Sd-Output"), r.Header.Get("Destination")} {
+		if isHttpdSecret(p) {
+			log.Println("HTTP", r.Method, "refused for secret path", p)
+			http.Error(w, "Name not found", http.StatusNotFound)
+			return
+		}
+	}
+
 	// function invocation
 	if r.Method == "POST" {
 
@@ -224,7 +303,7 @@ func (e *httpd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handle := base.NewDetachedHandle(e.root)
+	handle := base.NewDetachedHandle(httpdSecretless{e.root})
 
 	// TODO: escape pieces?
 	path, _ := url.PathUnescape(strings.TrimPrefix(r.RequestURI, "/~~"))
@@ -257,29 +336,9 @@ func (e *httpd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		obj := map[string]interface{}{
-			"name": entry.Name(),
-			"type": "Unknown",
-		}
-
-		// TODO: attempt to match against relevant shapes
-
-		switch entry := entry.(type) {
+		obj := render.JSON(entry, render.Options{MaxDepth: 1})
 
-		case base.File:
-			obj["type"] = "File"
-
-		case base.String:
-			obj["type"] = "String"
-			obj["value"] = entry.Get()
-
-		case base.Function:
-			// Functions don't say anything about themselves
-			// You need the Function shape to really get anything
-			// TODO: should be able to invoke tho
-			obj["type"] = "Function"
-
-		case base.Folder:
+		if entry, ok := entry.(base.Folder); ok {
 			// normally we'd redirect to keep HTML relative links working
 			// but the JSON clients should know what to do
 
@@ -299,45 +358,20 @@ func (e *httpd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 			log.Println("matching against", len(shapeList), "shapes in directory")
 
-			names := entry.Children()
-			entries := make([]map[string]interface{}, len(names))
-			for idx, name := range names {
-
-				// Fetch child to identify type
-				subType := "Unknown"
-				sub, ok := entry.Fetch(name)
-				if ok {
-					switch sub.(type) {
-					case base.Folder:
-						subType = "Folder"
-					case base.File:
-						subType = "File"
-					case base.String:
-						subType = "String"
-					case base.Function:
-						subType = "Function"
-					case base.Shape:
-						subType = "Shape"
-					}
-				}
-
+			children, _ := obj["children"].([]map[string]interface{})
+			for _, child := range children {
 				shapes := make([]string, 0)
-				for _, shape := range shapeList {
-					if shape.Check(e.ctx, sub) {
-						shapes = append(shapes, shape.Name())
+				if len(shapeList) > 0 {
+					name, _ := child["name"].(string)
+					sub, _ := entry.Fetch(name)
+					for _, shape := range shapeList {
+						if shape.Check(e.ctx, sub) {
+							shapes = append(shapes, shape.Name())
+						}
 					}
 				}
-
-				entries[idx] = map[string]interface{}{
-					"name":   name,
-					"type":   subType,
-					"shapes": shapes,
-				}
+				child["shapes"] = shapes
 			}
-
-			obj["type"] = "Folder"
-			obj["children"] = entries
-
 		}
 
 		json, err := json.Marshal(obj)
@@ -0,0 +1,126 @@
+package entries
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Ray scripts that init runs once services are up, in order
+// Missing scripts are skipped
+var bootScriptPaths = []string{
+	"/boot/init",
+	"/boot/cfg/init-script",
+}
+
+// Where the shell that runs boot scripts lives
+const bootShellPath = "/rom/bin/ray"
+
+// Runs each boot script through ray, copying output into the boot log
+// A failing script is reported, but doesn't keep the next from running
+func (s *initSvc) runBootScripts() {
+	defer s.bootLog.Close()
+
+	var failed []string
+	for _, scriptPath := range bootScriptPaths {
+		entry, ok := s.ctx.Get(scriptPath)
+		if !ok {
+			continue
+		}
+		script, ok := entry.(base.String)
+		if !ok {
+			log.Println("init: Boot script", scriptPath, "isn't a String, skipping")
+			continue
+		}
+
+		log.Println("init: Running boot script", scriptPath)
+		s.bootLog.Append(inmem.NewString("init", "Running "+scriptPath))
+		s.setBootStatus("running " + scriptPath)
+
+		if err := s.runBootScript(script); err != nil {
+			log.Println("init: Boot script", scriptPath, "failed -", err)
+			s.bootLog.Append(inmem.NewString("error", fmt.Sprintf("%s failed: %s", scriptPath, err)))
+			failed = append(failed, scriptPath)
+		}
+	}
+
+	if len(failed) > 0 {
+		s.setBootStatus(fmt.Sprintf("failed: %v", failed))
+	} else {
+		s.setBootStatus("done")
+		log.Println("init: Boot scripts finished")
+	}
+}
+
+// Hands one script to a fresh ray and waits for its result
+func (s *initSvc) runBootScript(script base.String) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ray panicked: %v", r)
+		}
+	}()
+
+	shell, ok := s.ctx.GetFolder(bootShellPath)
+	if !ok {
+		return fmt.Errorf("no shell at %s", bootShellPath)
+	}
+	invokeEntry, ok := shell.Fetch("invoke")
+	if !ok {
+		return fmt.Errorf("%s has no invoke function", bootShellPath)
+	}
+	invoke, ok := invokeEntry.(base.Function)
+	if !ok {
+		return fmt.Errorf("%s isn't invokable", bootShellPath)
+	}
+
+	bundle, ok := invoke.Invoke(s.ctx, script).(base.Folder)
+	if !ok {
+		return fmt.Errorf("%s didn't start a ray", bootShellPath)
+	}
+
+	// copy output over until the ray closes it
+	if outputEntry, ok := bundle.Fetch("output"); ok {
+		if output, ok := outputEntry.(base.Log); ok {
+			sub := output.Subscribe(nil)
+			for {
+				line, ok := sub.Next()
+				if !ok {
+					break
+				}
+				s.bootLog.Append(line)
+			}
+		}
+	}
+
+	resultEntry, ok := bundle.Fetch("result")
+	if !ok {
+		return fmt.Errorf("ray didn't offer a result")
+	}
+	result, ok := resultEntry.(base.Channel)
+	if !ok {
+		return fmt.Errorf("ray's result isn't a Channel")
+	}
+
+	value, ok := result.Next()
+	if !ok {
+		return fmt.Errorf("ray exited without a result")
+	}
+	if str, ok := value.(base.String); ok && str.Name() == "error" {
+		return fmt.Errorf("%s", str.Get())
+	}
+	return nil
+}
+
+func (s *initSvc) setBootStatus(status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bootStatus = status
+}
+
+func (s *initSvc) getBootStatus() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.bootStatus
+}
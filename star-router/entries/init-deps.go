@@ -0,0 +1,149 @@
+package entries
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+)
+
+// Reads the names of services that must be running before this one
+// Accepts a String of names separated by spaces or commas,
+// or a Folder of Strings (which is what a YAML list turns into)
+func readRequires(cfg base.Folder) (names []string) {
+	entry, ok := cfg.Fetch("requires")
+	if !ok {
+		return nil
+	}
+
+	switch entry := entry.(type) {
+
+	case base.String:
+		split := func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		}
+		names = strings.FieldsFunc(entry.Get(), split)
+
+	case base.Folder:
+		for _, key := range entry.Children() {
+			if child, ok := entry.Fetch(key); ok {
+				if str, ok := child.(base.String); ok && str.Get() != "" {
+					names = append(names, str.Get())
+				}
+			}
+		}
+	}
+	return
+}
+
+// Orders services so that each one comes after everything it requires
+// Ties are broken alphabetically so boot order is stable
+// Services caught in a dependency cycle are left out of the order
+// and returned with a description of the cycle instead
+func sortServices(services map[string]*service) (order []*service, cycles map[string]string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cycles = make(map[string]string)
+	marks := make(map[string]int)
+	var stack []string
+
+	var visit func(name string)
+	visit = func(name string) {
+		svc, ok := services[name]
+		if !ok {
+			// unknown names are reported when the service tries to start
+			return
+		}
+
+		switch marks[name] {
+		case visited:
+			return
+
+		case visiting:
+			// walk back up the stack to find where the loop begins
+			start := len(stack) - 1
+			for stack[start] != name {
+				start--
+			}
+			loop := append(append([]string{}, stack[start:]...), name)
+			desc := "dependency cycle: " + strings.Join(loop, " -> ")
+			for _, member := range loop {
+				if _, ok := cycles[member]; !ok {
+					cycles[member] = desc
+				}
+			}
+			return
+		}
+
+		marks[name] = visiting
+		stack = append(stack, name)
+
+		deps := append([]string{}, svc.requires...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			visit(dep)
+		}
+
+		stack = stack[:len(stack)-1]
+		marks[name] = visited
+		if _, inCycle := cycles[name]; !inCycle {
+			order = append(order, svc)
+		}
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+	return
+}
+
+// Retries the services that were blocked waiting on one that just started
+// This happens whatever their restart-policy says, since that's about exits
+func (s *initSvc) startBlockedDependents(name string) {
+	if s.isShuttingDown() {
+		return
+	}
+
+	s.lock.Lock()
+	var blocked []*service
+	for _, svc := range s.services {
+		if svc.getStatus().state != svcBlocked {
+			continue
+		}
+		for _, dep := range svc.requires {
+			if dep == name {
+				blocked = append(blocked, svc)
+				break
+			}
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].Name() < blocked[j].Name()
+	})
+	for _, svc := range blocked {
+		svc.lock.Lock()
+		wanted := svc.wanted
+		svc.lock.Unlock()
+		if !wanted {
+			continue
+		}
+
+		log.Println("init: Retrying", svc.Name(), "now that", name, "is up")
+		if err := s.startService(svc); err != nil {
+			log.Println("init: Still can't start", svc.Name(), "-", err)
+		}
+	}
+}
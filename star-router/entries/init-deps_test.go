@@ -0,0 +1,115 @@
+package entries
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Builds services from a map of name to space-separated requires
+func testServices(deps map[string]string) map[string]*service {
+	services := make(map[string]*service, len(deps))
+	for name, requires := range deps {
+		services[name] = &service{requires: strings.Fields(requires)}
+	}
+	return services
+}
+
+func serviceNames(services map[string]*service, order []*service) []string {
+	names := make([]string, len(order))
+	for i, svc := range order {
+		for name, candidate := range services {
+			if candidate == svc {
+				names[i] = name
+			}
+		}
+	}
+	return names
+}
+
+func TestSortServices(t *testing.T) {
+	cases := []struct {
+		name   string
+		deps   map[string]string
+		order  []string
+		cycles []string
+	}{
+		{
+			name:  "alphabetical without requires",
+			deps:  map[string]string{"httpd": "", "export": "", "ray-ssh": "", "redis-ns": ""},
+			order: []string{"export", "httpd", "ray-ssh", "redis-ns"},
+		},
+		{
+			name:  "requires come first",
+			deps:  map[string]string{"httpd": "", "ray-ssh": "redis-ns", "redis-ns": ""},
+			order: []string{"httpd", "redis-ns", "ray-ssh"},
+		},
+		{
+			name:  "chains",
+			deps:  map[string]string{"a": "b", "b": "c", "c": ""},
+			order: []string{"c", "b", "a"},
+		},
+		{
+			name:  "shared requires only start once",
+			deps:  map[string]string{"a": "c", "b": "c", "c": ""},
+			order: []string{"c", "a", "b"},
+		},
+		{
+			name:  "missing requires are skipped",
+			deps:  map[string]string{"a": "nowhere", "b": ""},
+			order: []string{"a", "b"},
+		},
+		{
+			name:   "cycles are left out",
+			deps:   map[string]string{"a": "b", "b": "a", "c": ""},
+			order:  []string{"c"},
+			cycles: []string{"a", "b"},
+		},
+		{
+			name:   "self requirement",
+			deps:   map[string]string{"a": "a", "b": ""},
+			order:  []string{"b"},
+			cycles: []string{"a"},
+		},
+		{
+			name:   "requiring a cycle isn't being in one",
+			deps:   map[string]string{"a": "b", "b": "c", "c": "b", "d": ""},
+			order:  []string{"a", "d"},
+			cycles: []string{"b", "c"},
+		},
+	}
+
+	for _, tc := range cases {
+		services := testServices(tc.deps)
+		order, cycles := sortServices(services)
+
+		if got := serviceNames(services, order); !reflect.DeepEqual(got, tc.order) {
+			t.Errorf("%s: order is %v, expected %v", tc.name, got, tc.order)
+		}
+
+		var cycled []string
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if _, ok := cycles[name]; ok {
+				cycled = append(cycled, name)
+			}
+		}
+		if !reflect.DeepEqual(cycled, tc.cycles) {
+			t.Errorf("%s: cycles are %v, expected %v", tc.name, cycled, tc.cycles)
+		}
+	}
+}
+
+func TestSortServicesIsStable(t *testing.T) {
+	deps := map[string]string{"a": "d", "b": "d", "c": "", "d": "", "e": "a c"}
+	var first []string
+	for i := 0; i < 20; i++ {
+		services := testServices(deps)
+		order, _ := sortServices(services)
+		names := serviceNames(services, order)
+		if first == nil {
+			first = names
+		} else if !reflect.DeepEqual(names, first) {
+			t.Fatalf("order changed between runs: %v then %v", first, names)
+		}
+	}
+}
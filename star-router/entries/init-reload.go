@@ -0,0 +1,182 @@
+package entries
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// How often init rereads the service config on its own
+const reconcileInterval = 30 * time.Second
+
+// Flattens a config folder into sorted key=value lines,
+// so that edits anywhere in the tree can be noticed
+func configFingerprint(cfg base.Folder) string {
+	var lines []string
+
+	var walk func(prefix string, folder base.Folder)
+	walk = func(prefix string, folder base.Folder) {
+		for _, name := range folder.Children() {
+			entry, ok := folder.Fetch(name)
+			if !ok {
+				continue
+			}
+			switch entry := entry.(type) {
+			case base.String:
+				lines = append(lines, prefix+name+"="+entry.Get())
+			case base.Link:
+				lines = append(lines, prefix+name+"->"+entry.Target())
+			case base.Folder:
+				lines = append(lines, prefix+name+"/")
+				walk(prefix+name+"/", entry)
+			}
+		}
+	}
+	walk("", cfg)
+
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// Brings the running services in line with the config folder
+// New services are started, removed ones are stopped,
+// and ones whose config changed are restarted with the new config
+func (s *initSvc) reconcile() (added, removed, changed []string, err error) {
+	defer func() {
+		// config backends like consul panic when they're unreachable
+		if r := recover(); r != nil {
+			err = fmt.Errorf("couldn't read service config: %v", r)
+		}
+	}()
+
+	// reading the config can be slow, so it's done before taking the lock
+	// Backends like redis-ns list nothing when they have trouble, so
+	// an empty or partly unreadable config skips the pass instead of
+	// stopping everything
+	names := s.cfgDir.Children()
+	s.lock.Lock()
+	known := len(s.services)
+	s.lock.Unlock()
+	if len(names) == 0 && known > 0 {
+		return nil, nil, nil, errors.New("service config is empty, keeping the running services")
+	}
+	desired := make(map[string]*service)
+	for _, name := range names {
+		entry, ok := s.cfgDir.Fetch(name)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("couldn't read the config for %s", name)
+		}
+		if folder, ok := entry.(base.Folder); ok {
+			desired[name] = newService(s, folder)
+		}
+	}
+
+	// the lock is only held to apply the difference
+	var stale []*service
+	s.lock.Lock()
+	for name, svc := range s.services {
+		next, ok := desired[name]
+		switch {
+		case !ok:
+			removed = append(removed, name)
+			stale = append(stale, svc)
+			delete(s.services, name)
+		case next.fingerprint != svc.fingerprint:
+			changed = append(changed, name)
+			stale = append(stale, svc)
+			s.services[name] = next
+		}
+	}
+	for name, next := range desired {
+		if _, ok := s.services[name]; !ok {
+			added = append(added, name)
+			s.services[name] = next
+		}
+	}
+	order, cycles := sortServices(s.services)
+	s.lock.Unlock()
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	// a service stopped by hand stays stopped through config edits
+	keepStopped := make(map[string]bool)
+	for _, svc := range stale {
+		svc.lock.Lock()
+		keepStopped[svc.Name()] = !svc.wanted && svc.getStatus().state == svcStopped
+		svc.lock.Unlock()
+
+		if err := s.stopService(svc); err != nil {
+			log.Println("init: Failed to stop", svc.Name(), "-", err)
+		}
+	}
+
+	// start the replacements in dependency order
+	fresh := make(map[string]bool)
+	for _, name := range append(added, changed...) {
+		fresh[name] = true
+	}
+	for name, reason := range cycles {
+		if fresh[name] {
+			log.Println("init: Not starting", name, "because of a", reason)
+			s.getService(name).setState(svcBlocked, errors.New(reason))
+		}
+	}
+	for _, svc := range order {
+		if !fresh[svc.Name()] {
+			continue
+		}
+		if keepStopped[svc.Name()] {
+			log.Println("init: Leaving", svc.Name(), "stopped")
+			svc.setState(svcStopped, nil)
+			continue
+		}
+		if err := s.startService(svc); err != nil {
+			log.Println("init: Failed to start", svc.Name(), "-", err)
+		}
+	}
+
+	if len(added)+len(removed)+len(changed) > 0 {
+		log.Println("init: Reloaded services - added", added, "removed", removed, "changed", changed)
+	}
+	return
+}
+
+// Rereads the config periodically, for backends that can change under us
+func (s *initSvc) reconcileLoop() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, _, err := s.reconcile(); err != nil {
+				log.Println("init: Reload failed -", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Function that reconciles services right away and says what it did
+func (s *initSvc) invokeReload(ctx base.Context, input base.Entry) (output base.Entry) {
+	added, removed, changed, err := s.reconcile()
+	if err != nil {
+		log.Println("init: Reload failed -", err)
+		return inmem.NewString("error", err.Error())
+	}
+
+	return inmem.NewFolderOf("reload",
+		inmem.NewString("added", strings.Join(added, " ")),
+		inmem.NewString("removed", strings.Join(removed, " ")),
+		inmem.NewString("restarted", strings.Join(changed, " ")),
+	).Freeze()
+}
@@ -0,0 +1,65 @@
+package entries
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How long services get to wind down before init gives up on them
+const shutdownTimeout = 20 * time.Second
+
+// Implemented by service outputs that hold resources,
+// like listeners or watches, which should be released when stopping
+type serviceStopper interface {
+	Stop()
+}
+
+// Blocks until the process is asked to exit, then stops everything
+func (s *initSvc) waitForSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	signal.Stop(signals)
+	log.Println("init: Received", sig, "- shutting down")
+	s.shutdown(shutdownTimeout)
+}
+
+// Stops services in reverse dependency order, within a deadline
+// Nothing gets restarted once this begins
+func (s *initSvc) shutdown(timeout time.Duration) {
+	close(s.done)
+
+	s.lock.Lock()
+	order, _ := sortServices(s.services)
+	s.lock.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		for i := len(order) - 1; i >= 0; i-- {
+			if err := s.stopService(order[i]); err != nil {
+				log.Println("init: Failed to stop", order[i].Name(), "-", err)
+			}
+		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		log.Println("init: All services stopped")
+	case <-time.After(timeout):
+		log.Println("init: Gave up on stopping services after", timeout)
+	}
+}
+
+func (s *initSvc) isShuttingDown() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package entries
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/extras"
+)
+
+// Bounds for the exponential backoff between restart attempts
+const (
+	restartMinDelay = 1 * time.Second
+	restartMaxDelay = 5 * time.Minute
+
+	// A run at least this long forgives any earlier failures
+	restartResetAfter = 1 * time.Minute
+)
+
+// Implemented by service outputs that can die on their own,
+// so that init can notice and apply the restart policy
+// A nil error means the service finished cleanly, and closing
+// the channel means it was stopped on purpose and stays stopped
+type serviceExiter interface {
+	Exited() <-chan error
+}
+
+// Reads a service's restart-policy, which is never, on-failure, or always
+func readRestartPolicy(cfg base.Folder) string {
+	policy, ok := extras.GetChildString(cfg, "restart-policy")
+	if !ok {
+		return "never"
+	}
+
+	switch policy {
+	case "never", "on-failure", "always":
+		return policy
+	default:
+		log.Println("init:", cfg.Name(), "has unknown restart-policy", policy, "- using never")
+		return "never"
+	}
+}
+
+// Invokes a service's driver without letting it take down the router
+// Panics, and outputs shaped like a String named "error", become errors
+func invokeService(fn base.Function, ctx base.Context, input base.Entry) (output base.Entry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("init: driver panicked: %v\n%s", r, debug.Stack())
+			output = nil
+			err = fmt.Errorf("driver panicked: %v", r)
+		}
+	}()
+
+	output = fn.Invoke(ctx, input)
+	if str, ok := output.(base.String); ok && str.Name() == "error" {
+		return nil, fmt.Errorf("driver failed: %s", str.Get())
+	}
+	return output, nil
+}
+
+// Queues another start attempt if the service's policy wants one
+// Callers are expected to hold the service's lock
+func (s *initSvc) scheduleRestart(svc *service, reason error) {
+	switch {
+	case svc.policy == "never":
+		return
+	case svc.policy == "on-failure" && reason == nil:
+		return
+	case s.isShuttingDown():
+		return
+	}
+
+	delay := restartMinDelay << uint(svc.failures)
+	if delay > restartMaxDelay || delay <= 0 {
+		delay = restartMaxDelay
+	}
+	svc.failures++
+
+	log.Println("init: Will restart", svc.Name(), "in", delay)
+	time.AfterFunc(delay, func() {
+		svc.lock.Lock()
+		wanted := svc.wanted
+		svc.lock.Unlock()
+		if !wanted {
+			// someone stopped the service in the meantime
+			return
+		}
+
+		if err := s.startService(svc); err != nil {
+			log.Println("init: Failed to restart", svc.Name(), "-", err)
+		}
+	})
+}
+
+// Waits for a running service's output to report that it died
+// gen identifies the run being watched, so stale watchers are ignored
+func (s *initSvc) watchExit(svc *service, gen int, exited <-chan error) {
+	err, open := <-exited
+
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	if svc.gen != gen || !svc.isRunning() {
+		// the service was stopped or restarted already
+		return
+	}
+
+	st := svc.getStatus()
+	if st.mountPath != "" {
+		s.ctx.Put(st.mountPath, nil)
+	}
+	if stopper, ok := svc.output.(serviceStopper); ok {
+		stopper.Stop()
+	}
+	svc.output = nil
+
+	switch {
+	case !open:
+		log.Println("init: Service", svc.Name(), "was stopped")
+		svc.setState(svcStopped, nil)
+	case err != nil:
+		log.Println("init: Service", svc.Name(), "died -", err)
+		svc.setState(svcFailed, err)
+	default:
+		log.Println("init: Service", svc.Name(), "exited")
+		svc.setState(svcStopped, nil)
+	}
+	svc.updateStatus(func(st *serviceStatus) {
+		st.mountPath = ""
+	})
+	if !open {
+		// the restart policy is only for services that went away themselves
+		svc.wanted = false
+		return
+	}
+
+	if time.Since(st.startedAt) >= restartResetAfter {
+		svc.failures = 0
+	}
+	s.scheduleRestart(svc, err)
+}
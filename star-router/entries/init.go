@@ -1,9 +1,12 @@
 package entries
 
 import (
+	"fmt"
 	"log"
 	"path"
-	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/stardustapp/core/base"
@@ -23,56 +26,133 @@ func initFunc(ctx base.Context, input base.Entry) (output base.Entry) {
 	log.Println("init: Bootstrapping...")
 
 	s := &initSvc{
-		ctx:      ctx,
-		cfgDir:   input.(base.Folder), // TODO
-		services: make(map[string]*service),
+		ctx:        ctx,
+		cfgDir:     input.(base.Folder), // TODO
+		services:   make(map[string]*service),
+		done:       make(chan struct{}),
+		bootLog:    inmem.NewLog("boot-log"),
+		bootStatus: "pending",
 	}
 
-	var names []string
-	for _, name := range s.cfgDir.Children() {
-		if folder, ok := s.cfgDir.Fetch(name); ok {
-			s.services[name] = newService(folder.(base.Folder))
-			names = append(names, name)
-		}
+	ctx.Put("/n/init", s)
+
+	// starting from nothing, every configured service is new
+	if _, _, _, err := s.reconcile(); err != nil {
+		log.Println("init: Couldn't start services -", err)
 	}
-	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	log.Println("init: All services started")
 
-	ctx.Put("/n/init", s)
+	go s.runBootScripts()
+	go s.reconcileLoop()
+	s.waitForSignal()
+	return nil
+}
+
+// Context for a long-term init processer
+type initSvc struct {
+	ctx    base.Context
+	cfgDir base.Folder
 
-	s.start(s.services["redis-ns"])
+	lock       sync.Mutex // guards the map, which reloads can change
+	services   map[string]*service
+	bootStatus string
 
-	for _, name := range names {
-		svc := s.services[name]
-		if !svc.running {
-			log.Println("init: Starting service", name)
-			s.start(svc)
-			if !svc.running {
-				log.Println("init: Failed to start", name)
-			}
+	done    chan struct{} // closed when shutting down
+	bootLog base.Log      // output from the boot scripts
+}
+
+var _ base.Folder = (*initSvc)(nil)
+
+// Explains why a service can't start yet, if any of its
+// required services aren't running
+func (s *initSvc) checkRequires(svc *service) (reason string) {
+	for _, name := range svc.requires {
+		dep := s.getService(name)
+		if dep == nil {
+			return fmt.Sprintf("requires unknown service %s", name)
+		}
+		if state := dep.getStatus().state; state != svcRunning {
+			return fmt.Sprintf("requires %s, which is %s", name, state)
 		}
 	}
-	log.Println("init: All services started")
+	return ""
+}
 
-	for {
-		time.Sleep(1000 * time.Millisecond)
+// Starts a service if it isn't running and its requirements are met
+// Used at boot, and at runtime through the service's folder
+func (s *initSvc) startService(svc *service) error {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	svc.wanted = true
+	if svc.isRunning() {
+		return nil
+	}
+	if reason := s.checkRequires(svc); reason != "" {
+		// retried by startBlockedDependents, not the restart policy
+		err := fmt.Errorf("blocked because it %s", reason)
+		svc.setState(svcBlocked, err)
+		return err
 	}
 
+	log.Println("init: Starting service", svc.Name())
+	svc.setState(svcStarting, nil)
+	if err := s.start(svc); err != nil {
+		svc.setState(svcFailed, err)
+		s.scheduleRestart(svc, err)
+		return err
+	}
+
+	// not while holding this lock, since their drivers can take a while
+	go s.startBlockedDependents(svc.Name())
 	return nil
 }
 
-// Context for a long-term init processer
-type initSvc struct {
-	ctx      base.Context
-	cfgDir   base.Folder
-	services map[string]*service
+// Stops a running service by unmounting whatever it mounted
+// Services that require this one are left alone
+func (s *initSvc) stopService(svc *service) error {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	svc.wanted = false
+	if !svc.isRunning() {
+		return nil
+	}
+
+	log.Println("init: Stopping service", svc.Name())
+	if mountPath := svc.getStatus().mountPath; mountPath != "" {
+		if ok := s.ctx.Put(mountPath, nil); !ok {
+			return fmt.Errorf("couldn't unmount %s", mountPath)
+		}
+		log.Println("init: Unmounted", mountPath)
+	}
+
+	// let the driver clean up after itself
+	if stopper, ok := svc.output.(serviceStopper); ok {
+		stopper.Stop()
+	}
+	svc.output = nil
+
+	svc.updateStatus(func(st *serviceStatus) {
+		st.state = svcStopped
+		st.mountPath = ""
+	})
+	return nil
 }
 
-var _ base.Folder = (*initSvc)(nil)
+func (s *initSvc) restartService(svc *service) error {
+	if err := s.stopService(svc); err != nil {
+		return err
+	}
+	return s.startService(svc)
+}
 
-func (s *initSvc) start(svc *service) {
+// Invokes the service's driver and mounts the output
+// Callers are expected to hold the service's lock
+func (s *initSvc) start(svc *service) error {
 	runPath, ok := extras.GetChildString(svc.cfgDir, "path")
 	if !ok {
-		return
+		return fmt.Errorf("no path configured")
 	}
 
 	// things about the invocation function
@@ -90,7 +170,7 @@ func (s *initSvc) start(svc *service) {
 		if runEntry, ok := input.Fetch("invoke"); ok {
 			runFunc = runEntry.(base.Function)
 		} else {
-			return
+			return fmt.Errorf("%s has no invoke function", runPath)
 		}
 
 		inputShape, ok = s.ctx.GetShape(path.Join(runPath, "input-shape"))
@@ -99,8 +179,7 @@ func (s *initSvc) start(svc *service) {
 		}
 
 	default:
-		log.Println("init:", runPath, "is not executable")
-		return
+		return fmt.Errorf("%s is not executable", runPath)
 
 	}
 
@@ -109,19 +188,36 @@ func (s *initSvc) start(svc *service) {
 	if ok {
 		inputEntry, ok = s.ctx.Get(inputPath)
 		if !ok {
-			return
+			return fmt.Errorf("input %s doesn't exist", inputPath)
 		}
 	} else {
 		inputEntry, _ = svc.cfgDir.Fetch("input")
 	}
 
+	var violations []string
 	if inputShape != nil {
-		if ok := inputShape.Check(s.ctx, inputEntry); !ok {
-			log.Println("Entry", inputPath, "doesn't match shape", inputShape, "for", runPath)
+		for _, v := range checkShape(s.ctx, inputShape, inputEntry) {
+			violations = append(violations, v.String())
+		}
+	}
+	svc.updateStatus(func(st *serviceStatus) {
+		st.inputViolations = violations
+	})
+
+	if len(violations) > 0 {
+		log.Println("init: Input for", svc.Name(), "doesn't match the input shape of", runPath)
+		for _, v := range violations {
+			log.Println("init:   -", v)
+		}
+		if svc.strictInput {
+			return fmt.Errorf("input doesn't match shape: %s", strings.Join(violations, "; "))
 		}
 	}
 
-	output := runFunc.Invoke(s.ctx, inputEntry)
+	output, err := invokeService(runFunc, s.ctx, inputEntry)
+	if err != nil {
+		return err
+	}
 
 	mountPath, ok := extras.GetChildString(svc.cfgDir, "mount-path")
 	if !ok {
@@ -130,14 +226,42 @@ func (s *initSvc) start(svc *service) {
 
 	if mountPath != "" && output != nil {
 		if ok = s.ctx.Put(mountPath, output); !ok {
-			log.Println("Couldn't mount to", mountPath)
-			return
+			if stopper, ok := output.(serviceStopper); ok {
+				stopper.Stop()
+			}
+			return fmt.Errorf("couldn't mount to %s", mountPath)
 		}
 		log.Println("Mounted to", mountPath)
 		//c.writeOut(cmd, fmt.Sprintf("Wrote result to %s", args[2]))
+	} else {
+		mountPath = ""
+	}
+
+	svc.updateStatus(func(st *serviceStatus) {
+		st.state = svcRunning
+		st.mountPath = mountPath
+		st.startedAt = time.Now()
+		if svc.gen > 0 {
+			// counted here so manual and automatic restarts only count once
+			st.restarts++
+		}
+	})
+	svc.output = output
+	svc.gen++
+
+	// keep an eye on outputs that can die later
+	if exiter, ok := output.(serviceExiter); ok {
+		go s.watchExit(svc, svc.gen, exiter.Exited())
+	} else {
+		svc.failures = 0
 	}
+	return nil
+}
 
-	svc.running = true
+func (s *initSvc) getService(name string) *service {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.services[name]
 }
 
 func (e *initSvc) Name() string {
@@ -145,35 +269,139 @@ func (e *initSvc) Name() string {
 }
 
 func (e *initSvc) Children() []string {
-	names := make([]string, len(e.services))
-	i := 0
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	names := make([]string, 0, len(e.services)+3)
 	for k := range e.services {
-		names[i] = k
-		i++
+		names = append(names, k)
 	}
-	return names
+	return append(names, "reload", "boot-log", "boot-status")
 }
 
 func (e *initSvc) Fetch(name string) (entry base.Entry, ok bool) {
-	entry, ok = e.services[name]
-	return
+	switch name {
+	case "reload":
+		return inmem.NewFunction("reload", e.invokeReload), true
+	case "boot-log":
+		return e.bootLog, true
+	case "boot-status":
+		return inmem.NewString("boot-status", e.getBootStatus()), true
+	}
+
+	if svc := e.getService(name); svc != nil {
+		return svc, true
+	}
+	return nil, false
 }
 
 func (e *initSvc) Put(name string, entry base.Entry) (ok bool) {
 	return false
 }
 
+// Lifecycle states that a service can be in
+const (
+	svcPending  = "pending"
+	svcStarting = "starting"
+	svcRunning  = "running"
+	svcFailed   = "failed"
+	svcBlocked  = "blocked"
+	svcStopped  = "stopped"
+)
+
 type service struct {
-	cfgDir  base.Folder
-	running bool
+	init        *initSvc
+	cfgDir      base.Folder
+	fingerprint string // config as of creation, to notice edits
+	requires    []string
+	policy      string // restart-policy
+	strictInput bool   // refuse to start on input shape mismatches
+
+	// held while starting or stopping, which can take a while
+	lock     sync.Mutex
+	wanted   bool       // whether the service should be kept running
+	output   base.Entry // from the driver, while running
+	gen      int        // counts runs, to ignore stale exits
+	failures int        // consecutive failures, for backoff
+
+	// kept separate so status reads don't wait on a slow driver
+	statusLock sync.Mutex
+	status     serviceStatus
+}
+
+// What's presented in a service's status folder
+type serviceStatus struct {
+	state     string
+	lastError string
+	mountPath string // where the output was mounted, if anywhere
+	startedAt time.Time
+	restarts  int // runs after the first, however they came about
+
+	// from the last start attempt
+	inputViolations []string
 }
 
 var _ base.Folder = (*service)(nil)
 
-func newService(cfg base.Folder) *service {
+func newService(init *initSvc, cfg base.Folder) *service {
+	strictInput, _ := extras.GetChildString(cfg, "strict-input")
 	return &service{
-		cfgDir: cfg,
+		init:        init,
+		cfgDir:      cfg,
+		fingerprint: configFingerprint(cfg),
+		requires:    readRequires(cfg),
+		policy:      readRestartPolicy(cfg),
+		strictInput: strictInput == "yes",
+		status: serviceStatus{
+			state: svcPending,
+		},
+	}
+}
+
+func (e *service) getStatus() serviceStatus {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+	return e.status
+}
+
+func (e *service) updateStatus(fn func(st *serviceStatus)) {
+	e.statusLock.Lock()
+	defer e.statusLock.Unlock()
+	fn(&e.status)
+}
+
+// Moves to a new state, remembering the error if there is one
+func (e *service) setState(state string, err error) {
+	e.updateStatus(func(st *serviceStatus) {
+		st.state = state
+		if err != nil {
+			st.lastError = err.Error()
+		}
+	})
+}
+
+func (e *service) isRunning() bool {
+	return e.getStatus().state == svcRunning
+}
+
+// Presents a snapshot of the status as Strings
+func (e *service) getStatusFolder() base.Folder {
+	st := e.getStatus()
+
+	var startedAt string
+	if !st.startedAt.IsZero() {
+		startedAt = st.startedAt.Format(time.RFC3339)
 	}
+
+	return inmem.NewFolderOf("status",
+		inmem.NewString("state", st.state),
+		inmem.NewString("last-error", st.lastError),
+		inmem.NewString("started-at", startedAt),
+		inmem.NewString("restart-count", strconv.Itoa(st.restarts)),
+		inmem.NewString("restart-policy", e.policy),
+		inmem.NewString("mount-path", st.mountPath),
+		inmem.NewString("input-violations", strings.Join(st.inputViolations, "\n")),
+	).Freeze()
 }
 
 func (e *service) Name() string {
@@ -182,17 +410,25 @@ func (e *service) Name() string {
 
 func (e *service) Children() []string {
 	names := e.cfgDir.Children()
-	names = append(names, "running")
+	names = append(names, "running", "restart", "status")
 	return names
 }
 
 func (e *service) Fetch(name string) (entry base.Entry, ok bool) {
-	if name == "running" {
+	switch name {
+
+	case "running":
 		running := "no"
-		if e.running {
+		if e.isRunning() {
 			running = "yes"
 		}
 		return inmem.NewString("running", running), true
+
+	case "restart":
+		return inmem.NewFunction("restart", e.invokeRestart), true
+
+	case "status":
+		return e.getStatusFolder(), true
 	}
 
 	// fallback to config dir
@@ -200,7 +436,38 @@ func (e *service) Fetch(name string) (entry base.Entry, ok bool) {
 	return
 }
 
+// Accepts "yes" or "no" as "running" to start or stop the service
 func (e *service) Put(name string, entry base.Entry) (ok bool) {
-	// TODO: support writing to "running"
-	return false
+	if name != "running" {
+		return false
+	}
+	str, ok := entry.(base.String)
+	if !ok {
+		return false
+	}
+
+	var err error
+	switch str.Get() {
+	case "yes":
+		err = e.init.startService(e)
+	case "no":
+		err = e.init.stopService(e)
+	default:
+		return false
+	}
+
+	if err != nil {
+		log.Println("init: Couldn't set", e.Name(), "running to", str.Get(), "-", err)
+		return false
+	}
+	return true
+}
+
+// Function that stops and starts the service again
+func (e *service) invokeRestart(ctx base.Context, input base.Entry) (output base.Entry) {
+	if err := e.init.restartService(e); err != nil {
+		log.Println("init: Couldn't restart", e.Name(), "-", err)
+		return inmem.NewString("error", err.Error())
+	}
+	return nil
 }
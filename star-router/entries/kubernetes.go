@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stardustapp/core/base"
@@ -17,6 +18,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -60,7 +62,9 @@ func startKubernetes(ctx base.Context, input base.Entry) (output base.Entry) {
 
 	return &kubeApi{
 		//config: config,
-		svc: clientset,
+		svc:      clientset,
+		watchers: make(map[watch.Interface]bool),
+		done:     make(chan struct{}),
 	}
 }
 
@@ -68,6 +72,10 @@ func startKubernetes(ctx base.Context, input base.Entry) (output base.Entry) {
 type kubeApi struct {
 	//config *rest.Config
 	svc *kubernetes.Clientset
+
+	lock     sync.Mutex
+	watchers map[watch.Interface]bool // open pod watches, closed on Stop
+	done     chan struct{}            // closed on Stop, ends polling
 }
 
 var _ base.Folder = (*kubeApi)(nil)
@@ -97,14 +105,14 @@ func (e *kubeApi) Fetch(name string) (entry base.Entry, ok bool) {
 
 	case "run-pod":
 		return inmem.NewFolderOf("run-pod",
-			&kubeRunPodFunc{e.svc},
+			&kubeRunPodFunc{e.svc, e},
 			kubeRunPodShape,
 			stringOutputShape,
 		).Freeze(), true
 
 	case "deploy-svc":
 		return inmem.NewFolderOf("deploy-svc",
-			&kubeDeploySvcFunc{e.svc},
+			&kubeDeploySvcFunc{e.svc, e},
 			kubeDeploySvcShape,
 			stringOutputShape,
 		).Freeze(), true
@@ -125,6 +133,43 @@ func (e *kubeApi) Put(name string, entry base.Entry) (ok bool) {
 	return false
 }
 
+// Remembers an open watch so Stop can close it
+// Returns false if the API is already stopped
+func (e *kubeApi) addWatcher(watcher watch.Interface) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	select {
+	case <-e.done:
+		return false
+	default:
+		e.watchers[watcher] = true
+		return true
+	}
+}
+
+func (e *kubeApi) removeWatcher(watcher watch.Interface) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.watchers, watcher)
+}
+
+// Closes open watches and abandons any polling
+func (e *kubeApi) Stop() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	select {
+	case <-e.done:
+		return
+	default:
+		close(e.done)
+	}
+	for watcher := range e.watchers {
+		watcher.Stop()
+	}
+	log.Println("k8s: Stopped", len(e.watchers), "watches")
+	e.watchers = nil
+}
+
 var kubeListPodsShape *inmem.Shape = inmem.NewShape(
 	inmem.NewFolderOf("input-shape",
 		inmem.NewString("type", "Folder"),
@@ -173,6 +218,7 @@ var kubeRunPodShape *inmem.Shape = inmem.NewShape(
 
 type kubeRunPodFunc struct {
 	svc *kubernetes.Clientset
+	api *kubeApi
 }
 
 var _ base.Function = (*kubeRunPodFunc)(nil)
@@ -283,6 +329,11 @@ func (e *kubeRunPodFunc) Invoke(ctx base.Context, input base.Entry) (output base
 		log.Println("Pod watching failed:", err)
 		return inmem.NewString("error", err.Error())
 	}
+	if !e.api.addWatcher(watcher) {
+		watcher.Stop()
+		return inmem.NewString("error", "kubernetes client is stopped")
+	}
+	defer e.api.removeWatcher(watcher)
 
 	var logs bytes.Buffer
 	var terminated bool
@@ -329,6 +380,7 @@ var kubeDeploySvcShape *inmem.Shape = inmem.NewShape(
 
 type kubeDeploySvcFunc struct {
 	svc *kubernetes.Clientset
+	api *kubeApi
 }
 
 var _ base.Function = (*kubeDeploySvcFunc)(nil)
@@ -497,7 +549,11 @@ func (e *kubeDeploySvcFunc) Invoke(ctx base.Context, input base.Entry) (output b
 	// Wait for deployment to stabilize
 	var ready bool
 	for !ready { // condition.Status != corev1.ConditionTrue || condition.Reason != "NewReplicaSetAvailable" {
-		time.Sleep(1000 * time.Millisecond)
+		select {
+		case <-time.After(1000 * time.Millisecond):
+		case <-e.api.done:
+			return inmem.NewString("error", "kubernetes client is stopped")
+		}
 		depl, err := deployments.Get(desiredDeployment.ObjectMeta.Name, metav1.GetOptions{})
 		if err != nil {
 			log.Println("Deployment polling failed:", err)
@@ -81,18 +81,14 @@ func (e *osFolder) Fetch(name string) (entry base.Entry, ok bool) {
 		return
 	}
 
-	fi, err := os.Open(path.Join(e.root, p))
-	if err != nil {
-		return
-	}
-	stat, err := fi.Stat()
+	stat, err := os.Stat(path.Join(e.root, p))
 	if err != nil {
 		return
 	}
 
 	switch mode := stat.Mode(); {
 	case mode.IsRegular():
-		return &osFile{e.root, p, fi}, true
+		return &osFile{e.root, p}, true
 	case mode.IsDir():
 		return &osFolder{e.root, p}, true
 	// case mode&os.ModeSymlink != 0:
@@ -160,10 +156,10 @@ func (e *osFolder) Put(name string, entry base.Entry) (ok bool) {
 }
 
 // Byte buffer backed by host operating system
+// Each operation opens the file anew, so no handles are held open
 type osFile struct {
 	root string
 	path string
-	file *os.File
 }
 
 var _ base.File = (*osFile)(nil)
@@ -172,16 +168,27 @@ func (e *osFile) Name() string {
 	return path.Base(e.path)
 }
 
+func (e *osFile) hostPath() string {
+	return path.Join(e.root, e.path)
+}
+
 func (e *osFile) GetSize() int64 {
-	if stat, err := e.file.Stat(); err == nil {
+	if stat, err := os.Stat(e.hostPath()); err == nil {
 		return stat.Size()
 	}
 	return -1
 }
 
 func (e *osFile) Read(offset int64, numBytes int) (data []byte) {
+	file, err := os.Open(e.hostPath())
+	if err != nil {
+		log.Println("read error on", e.path, "offset", offset, "err", err)
+		return
+	}
+	defer file.Close()
+
 	buf := make([]byte, numBytes)
-	if n, err := e.file.ReadAt(buf, offset); numBytes > 0 {
+	if n, err := file.ReadAt(buf, offset); numBytes > 0 {
 		data = buf[0:n]
 	} else {
 		log.Println("read error on", e.path, "offset", offset, "err", err)
@@ -190,7 +197,14 @@ func (e *osFile) Read(offset int64, numBytes int) (data []byte) {
 }
 
 func (e *osFile) Write(offset int64, data []byte) (numBytes int) {
-	if n, err := e.file.WriteAt(data, offset); err != nil {
+	file, err := os.OpenFile(e.hostPath(), os.O_WRONLY, 0)
+	if err != nil {
+		log.Println("write error on", e.path, "offset", offset, "err", err)
+		return -1
+	}
+	defer file.Close()
+
+	if n, err := file.WriteAt(data, offset); err != nil {
 		log.Println("write error on", e.path, "offset", offset, "err", err)
 		return -1
 	} else {
@@ -199,8 +213,6 @@ func (e *osFile) Write(offset int64, data []byte) (numBytes int) {
 }
 
 func (e *osFile) Truncate() (ok bool) {
-	err := e.file.Truncate(0)
+	err := os.Truncate(e.hostPath(), 0)
 	return err == nil
 }
-
-// TODO: close
@@ -0,0 +1,286 @@
+package entries
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Commands that change the namespace
+// Everything goes through Put, so that every driver sees the same calls
+
+func (c *rayCtx) resolvePath(p string) string {
+	return path.Join(c.cwd.value, p)
+}
+
+// Splits out leading flags, like -r, from the rest of the args
+func splitRayFlags(args []string) (flags map[string]bool, rest []string) {
+	flags = make(map[string]bool)
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return flags, args[i:]
+		}
+		for _, flag := range arg[1:] {
+			flags[string(flag)] = true
+		}
+	}
+	return flags, nil
+}
+
+// Where a cp or mv should land, which is inside
+// the destination if that's an existing folder
+func (c *rayCtx) copyDestination(src, dst string) string {
+	if _, ok := c.ctx.GetFolder(dst); ok {
+		return path.Join(dst, path.Base(src))
+	}
+	return dst
+}
+
+// cp [-r] SRC DST
+func (c *rayCtx) cmdCopy(cmd string, args []string) bool {
+	flags, args := splitRayFlags(args)
+	if len(args) != 2 {
+		c.writeErr(cmd, "Usage: cp [-r] SRC DST")
+		return false
+	}
+
+	src := c.resolvePath(args[0])
+	entry, ok := c.ctx.Get(src)
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", src))
+		return false
+	}
+	dst := c.copyDestination(src, c.resolvePath(args[1]))
+
+	if _, isFolder := entry.(base.Folder); isFolder {
+		if !flags["r"] {
+			c.writeErr(cmd, fmt.Sprintf("%s is a folder, use -r to copy it", src))
+			return false
+		}
+		if dst == src || strings.HasPrefix(dst, src+"/") {
+			c.writeErr(cmd, fmt.Sprintf("Can't copy %s into itself", src))
+			return false
+		}
+	}
+
+	parent, ok := c.ctx.GetFolder(path.Dir(dst))
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("No such folder %s", path.Dir(dst)))
+		return false
+	}
+	return c.copyEntry(cmd, parent, path.Base(dst), entry)
+}
+
+// Copies an entry into a folder under a name
+// Folders are recreated and filled one child at a time,
+// rather than handing the driver a foreign folder
+func (c *rayCtx) copyEntry(cmd string, parent base.Folder, name string, entry base.Entry) bool {
+	switch entry := entry.(type) {
+
+	case base.Folder:
+		if !parent.Put(name, inmem.NewFolder(name)) {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't create folder %s", name))
+			return false
+		}
+		destEntry, ok := parent.Fetch(name)
+		dest, isFolder := destEntry.(base.Folder)
+		if !ok || !isFolder {
+			c.writeErr(cmd, fmt.Sprintf("Created folder %s vanished", name))
+			return false
+		}
+
+		ok = true
+		for _, childName := range entry.Children() {
+			child, found := entry.Fetch(childName)
+			if !found {
+				c.writeErr(cmd, fmt.Sprintf("Couldn't read %s from %s", childName, entry.Name()))
+				ok = false
+				continue
+			}
+			if !c.copyEntry(cmd, dest, childName, child) {
+				ok = false
+			}
+		}
+		return ok
+
+	case base.String:
+		entry = inmem.NewString(name, entry.Get())
+		if !parent.Put(name, entry) {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", name))
+			return false
+		}
+		return true
+
+	case base.Link:
+		if !parent.Put(name, inmem.NewLink(name, entry.Target())) {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", name))
+			return false
+		}
+		return true
+
+	default:
+		// files and such are left to the driver to store
+		if !parent.Put(name, entry) {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", name))
+			return false
+		}
+		return true
+	}
+}
+
+// mv SRC DST
+// Hands the entry itself to the destination, then unlinks the source,
+// so mounts can be moved without copying what's behind them
+func (c *rayCtx) cmdMove(cmd string, args []string) bool {
+	if len(args) != 2 {
+		c.writeErr(cmd, "Usage: mv SRC DST")
+		return false
+	}
+
+	src := c.resolvePath(args[0])
+	entry, ok := c.ctx.Get(src)
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", src))
+		return false
+	}
+	dst := c.copyDestination(src, c.resolvePath(args[1]))
+	if dst == src {
+		return true
+	}
+	if strings.HasPrefix(dst, src+"/") {
+		c.writeErr(cmd, fmt.Sprintf("Can't move %s into itself", src))
+		return false
+	}
+
+	// strings take on their new name
+	if str, ok := entry.(base.String); ok {
+		entry = inmem.NewString(path.Base(dst), str.Get())
+	}
+
+	if !c.ctx.Put(dst, entry) {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", dst))
+		return false
+	}
+	if !c.ctx.Put(src, nil) {
+		c.writeErr(cmd, fmt.Sprintf("Copied to %s but couldn't remove %s", dst, src))
+		return false
+	}
+	return true
+}
+
+// rm [-r] PATH...
+func (c *rayCtx) cmdRemove(cmd string, args []string) bool {
+	flags, args := splitRayFlags(args)
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: rm [-r] PATH...")
+		return false
+	}
+
+	ok := true
+	for _, arg := range args {
+		target := c.resolvePath(arg)
+		entry, found := c.ctx.Get(target)
+		if !found {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+			ok = false
+			continue
+		}
+
+		if folder, isFolder := entry.(base.Folder); isFolder && !flags["r"] && len(folder.Children()) > 0 {
+			c.writeErr(cmd, fmt.Sprintf("%s isn't empty, use -r to remove it", target))
+			ok = false
+			continue
+		}
+
+		if !c.ctx.Put(target, nil) {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't remove %s", target))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// mkdir [-p] PATH...
+func (c *rayCtx) cmdMakeFolder(cmd string, args []string) bool {
+	flags, args := splitRayFlags(args)
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: mkdir [-p] PATH...")
+		return false
+	}
+
+	ok := true
+	for _, arg := range args {
+		target := c.resolvePath(arg)
+
+		var missing []string
+		if flags["p"] {
+			// create each missing parent on the way down
+			for dir := target; dir != "/"; dir = path.Dir(dir) {
+				if _, exists := c.ctx.Get(dir); exists {
+					break
+				}
+				missing = append([]string{dir}, missing...)
+			}
+		} else if _, exists := c.ctx.Get(target); exists {
+			c.writeErr(cmd, fmt.Sprintf("%s already exists", target))
+			ok = false
+			continue
+		} else {
+			missing = []string{target}
+		}
+
+		for _, dir := range missing {
+			if !c.ctx.Put(dir, inmem.NewFolder(path.Base(dir))) {
+				c.writeErr(cmd, fmt.Sprintf("Couldn't create %s", dir))
+				ok = false
+				break
+			}
+		}
+	}
+	return ok
+}
+
+// write PATH TEXT...
+// Without any text, writes whatever was piped in
+func (c *rayCtx) cmdWrite(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: write PATH TEXT...")
+		return false
+	}
+	target := c.resolvePath(args[0])
+
+	text := strings.Join(args[1:], " ")
+	if len(args) == 1 && stdio.input != nil {
+		str, ok := stdio.input.(base.String)
+		if !ok {
+			c.writeErr(cmd, "Only text can be written")
+			return false
+		}
+		text = str.Get()
+	}
+
+	if !c.ctx.Put(target, inmem.NewString(path.Base(target), text)) {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", target))
+		return false
+	}
+	return true
+}
+
+// ln TARGET PATH
+func (c *rayCtx) cmdLink(cmd string, args []string) bool {
+	if len(args) != 2 {
+		c.writeErr(cmd, "Usage: ln TARGET PATH")
+		return false
+	}
+	target := c.resolvePath(args[0])
+	linkPath := c.resolvePath(args[1])
+
+	if !c.ctx.Put(linkPath, inmem.NewLink(path.Base(linkPath), target)) {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't write %s", linkPath))
+		return false
+	}
+	return true
+}
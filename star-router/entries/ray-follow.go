@@ -0,0 +1,392 @@
+package entries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danopia/stardust/star-router/render"
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Commands that stay running to follow Logs and Channels,
+// until the source closes or the session is interrupted
+
+// How many lines tail shows of a String when not told otherwise
+const rayTailLines = 10
+
+// Exit status of whatever Ctrl-C stopped, like a shell killed by SIGINT
+const rayInterruptedStatus = 130
+
+// Asks whatever is running to stop
+// Only one interrupt is held, so extra presses don't pile up
+func (c *rayCtx) interrupt() {
+	select {
+	case c.interrupts <- struct{}{}:
+	default:
+	}
+}
+
+// Throws away an interrupt that arrived while nothing was running,
+// so it can't cut the next command short
+func (c *rayCtx) clearInterrupt() {
+	select {
+	case <-c.interrupts:
+	default:
+	}
+}
+
+// Whether Ctrl-C came in since the current line started
+// The interrupt stays pending, so that every block and script
+// the command is nested in stops as well
+func (c *rayCtx) interrupted() bool {
+	select {
+	case <-c.interrupts:
+		c.interrupt()
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits on a channel that closes when it's done, or an interrupt,
+// which is left pending like interrupted() does
+func (c *rayCtx) waitOrInterrupt(done <-chan struct{}) (interrupted bool) {
+	select {
+	case <-done:
+		return false
+	case <-c.interrupts:
+		c.interrupt()
+		return true
+	}
+}
+
+// Hands each entry from a Log subscription to each() until the Log
+// ends, each() returns false, or an interrupt comes in
+// Next() blocks, so it runs off to the side until the subscription
+// is stopped, which tail does once this returns
+func (c *rayCtx) follow(sub base.Channel, each func(base.Entry) bool) (interrupted bool) {
+	entries := make(chan base.Entry)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(entries)
+		for {
+			entry, ok := sub.Next()
+			if !ok {
+				return
+			}
+			select {
+			case entries <- entry:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok || !each(entry) {
+				return false
+			}
+		case <-c.interrupts:
+			c.interrupt()
+			return true
+		}
+	}
+}
+
+// Reads a Channel for watch, one entry at a time
+// Next() can't be called off, so a read that's still waiting when watch
+// is interrupted carries on, and whatever it gets is kept for the next
+// watch of the same path instead of being pushed back out of order
+type rayChannelReader struct {
+	channel base.Channel
+	turn    chan struct{}       // held by whoever is watching
+	reads   chan rayChannelRead // outcome of the read in flight
+	reading bool                // whether a read is in flight, guarded by turn
+}
+
+type rayChannelRead struct {
+	entry base.Entry
+	ok    bool
+}
+
+func newRayChannelReader(channel base.Channel) *rayChannelReader {
+	return &rayChannelReader{
+		channel: channel,
+		turn:    make(chan struct{}, 1),
+		reads:   make(chan rayChannelRead, 1),
+	}
+}
+
+// Takes the next entry, unless the ray is interrupted first
+func (r *rayChannelReader) next(c *rayCtx) (entry base.Entry, ok, interrupted bool) {
+	select {
+	case r.turn <- struct{}{}:
+	case <-c.interrupts:
+		c.interrupt()
+		return nil, false, true
+	}
+	defer func() { <-r.turn }()
+
+	if !r.reading {
+		r.reading = true
+		go func() {
+			entry, ok := r.channel.Next()
+			r.reads <- rayChannelRead{entry, ok}
+		}()
+	}
+
+	select {
+	case read := <-r.reads:
+		r.reading = false
+		return read.entry, read.ok, false
+	case <-c.interrupts:
+		c.interrupt()
+		return nil, false, true
+	}
+}
+
+// Once nobody will watch through this reader again, an entry that
+// its last read turns up goes back to the Channel for someone else
+func (r *rayChannelReader) release() {
+	go func() {
+		r.turn <- struct{}{}
+		if r.reading {
+			if read := <-r.reads; read.ok {
+				r.channel.Push(read.entry)
+			}
+		}
+	}()
+}
+
+// The session's Channel readers by path, shared with its jobs and scripts
+type rayChannelReaders struct {
+	lock    sync.Mutex
+	readers map[string]*rayChannelReader
+}
+
+func newRayChannelReaders() *rayChannelReaders {
+	return &rayChannelReaders{
+		readers: make(map[string]*rayChannelReader),
+	}
+}
+
+// Finds the reader for a path, starting over if another Channel
+// has been put there since
+func (e *rayChannelReaders) get(target string, channel base.Channel) *rayChannelReader {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	reader, ok := e.readers[target]
+	if ok && reader.channel == channel {
+		return reader
+	}
+	if ok {
+		reader.release()
+	}
+	reader = newRayChannelReader(channel)
+	e.readers[target] = reader
+	return reader
+}
+
+// Lets go of every reader, for when the session ends
+func (e *rayChannelReaders) releaseAll() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for target, reader := range e.readers {
+		reader.release()
+		delete(e.readers, target)
+	}
+}
+
+// Whether a Log is where this ray's own output goes,
+// since following it would echo every line back into it forever
+func (c *rayCtx) isOwnOutput(entry base.Log) bool {
+	return entry == c.sessionOutput()
+}
+
+// Prints a followed entry, passing it to the session log untouched
+// so that another session's output, errors included, looks the same
+func (s *rayStdio) relay(label string, entry base.Entry) {
+	if s.capture == nil {
+		s.ray.output.Append(entry)
+		return
+	}
+	if str, ok := entry.(base.String); ok {
+		s.writeOut(label, str.Get())
+		return
+	}
+	for _, line := range render.Text(entry, render.DefaultOptions) {
+		s.writeOut(label, line)
+	}
+}
+
+// Reads a -n COUNT option off the front of the args
+func (c *rayCtx) countFlag(cmd string, args []string) (count int, rest []string, ok bool) {
+	count = -1
+	if len(args) == 0 || args[0] != "-n" {
+		return count, args, true
+	}
+	if len(args) < 2 {
+		c.writeErr(cmd, "Missing value for -n")
+		return
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		c.writeErr(cmd, fmt.Sprintf("Bad count %q", args[1]))
+		return
+	}
+	return count, args[2:], true
+}
+
+// tail [-f] [-n COUNT] PATH
+// Shows the end of a String, or follows a Log with -f
+// When following, a count stops it after that many entries
+func (c *rayCtx) cmdTail(cmd string, args []string, stdio *rayStdio) bool {
+	follow := len(args) > 0 && args[0] == "-f"
+	if follow {
+		args = args[1:]
+	}
+	count, args, ok := c.countFlag(cmd, args)
+	if !ok {
+		return false
+	}
+	if len(args) != 1 {
+		c.writeErr(cmd, "Usage: tail [-f] [-n COUNT] PATH")
+		return false
+	}
+
+	target := c.resolvePath(args[0])
+	entry, found := c.ctx.Get(target)
+	if !found {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+		return false
+	}
+
+	switch entry := entry.(type) {
+	case base.String:
+		if count < 0 {
+			count = rayTailLines
+		}
+		lines := strings.Split(entry.Get(), "\n")
+		if len(lines) > count {
+			lines = lines[len(lines)-count:]
+		}
+		for _, line := range lines {
+			stdio.writeOut(cmd, line)
+		}
+		return true
+
+	case base.Log:
+		if !follow {
+			c.writeErr(cmd, fmt.Sprintf("%s is a Log, which can only be followed with -f", target))
+			return false
+		}
+		if c.isOwnOutput(entry) {
+			c.writeErr(cmd, fmt.Sprintf("%s is this session's own output", target))
+			return false
+		}
+
+		stop := make(chan struct{})
+		defer close(stop)
+
+		c.follow(entry.Subscribe(stop), func(line base.Entry) bool {
+			stdio.relay(cmd, line)
+			count--
+			return count != 0
+		})
+		return true
+
+	default:
+		c.writeErr(cmd, fmt.Sprintf("Can't tail %s, it's a %s", target, render.TypeName(entry)))
+		return false
+	}
+}
+
+// watch [-n COUNT] PATH
+// Takes entries off a Channel as they arrive, which means
+// other readers of the Channel won't see them
+func (c *rayCtx) cmdWatch(cmd string, args []string, stdio *rayStdio) bool {
+	count, args, ok := c.countFlag(cmd, args)
+	if !ok {
+		return false
+	}
+	if len(args) != 1 {
+		c.writeErr(cmd, "Usage: watch [-n COUNT] PATH")
+		return false
+	}
+
+	target := c.resolvePath(args[0])
+	entry, found := c.ctx.Get(target)
+	if !found {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+		return false
+	}
+	channel, isChannel := entry.(base.Channel)
+	if !isChannel {
+		c.writeErr(cmd, fmt.Sprintf("%s isn't a Channel", target))
+		return false
+	}
+
+	reader := c.channels.get(target, channel)
+	var seen int
+	for seen != count {
+		entry, ok, interrupted := reader.next(c)
+		if interrupted {
+			return true
+		}
+		if !ok {
+			// running out early is a failure when a count was asked for
+			return count < 0
+		}
+		stdio.relay(cmd, entry)
+		seen++
+	}
+	return true
+}
+
+// push PATH [TEXT...]
+// Sends text, an inline {key=value} folder, or piped input into a Channel
+func (c *rayCtx) cmdPush(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: push PATH [TEXT...]")
+		return false
+	}
+
+	target := c.resolvePath(args[0])
+	entry, found := c.ctx.Get(target)
+	if !found {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+		return false
+	}
+	channel, isChannel := entry.(base.Channel)
+	if !isChannel {
+		c.writeErr(cmd, fmt.Sprintf("%s isn't a Channel", target))
+		return false
+	}
+
+	var value base.Entry
+	if literal, isLiteral := stdio.literal(1); isLiteral && len(args) == 2 {
+		value = literal
+	} else if len(args) > 1 {
+		value = inmem.NewString("message", strings.Join(args[1:], " "))
+	} else if stdio.input != nil {
+		value = stdio.input
+	} else {
+		c.writeErr(cmd, "Nothing to push, give some text or pipe something in")
+		return false
+	}
+
+	if !channel.Push(value) {
+		c.writeErr(cmd, fmt.Sprintf("%s is closed", target))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,274 @@
+package entries
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/danopia/stardust/star-router/render"
+	"github.com/stardustapp/core/base"
+)
+
+// Commands that look over the namespace without changing it
+
+// Where stat looks for shapes to match entries against
+const rayShapesPath = "/rom/shapes"
+
+// How deep tree goes when not told otherwise
+const rayTreeDepth = 3
+
+// How deep find and grep go when not told otherwise
+const raySearchDepth = 16
+
+// Visits everything under a folder, depth-first and in listing order
+// Drivers like osfs follow symlinks, so a tree can loop back on itself,
+// which is why every walk stops at maxDepth
+// Slow drivers make for long walks, so Ctrl-C and kill stop them
+// between entries, and then the walk returns false
+// The visitor can return false to skip descending into a folder
+type rayVisitor func(entryPath string, entry base.Entry, depth int) (descend bool)
+
+func (c *rayCtx) walkEntries(folder base.Folder, folderPath string, depth, maxDepth int, visit rayVisitor) (finished bool) {
+	if depth > maxDepth {
+		return true
+	}
+	for _, name := range folder.Children() {
+		if c.cancelled() || c.interrupted() {
+			return false
+		}
+		entry, ok := folder.Fetch(name)
+		if !ok {
+			continue
+		}
+		entryPath := path.Join(folderPath, name)
+		if !visit(entryPath, entry, depth) {
+			continue
+		}
+		if sub, ok := entry.(base.Folder); ok {
+			if !c.walkEntries(sub, entryPath, depth+1, maxDepth, visit) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tree [DEPTH] [PATH]
+func (c *rayCtx) cmdTree(cmd string, args []string, stdio *rayStdio) bool {
+	depth := rayTreeDepth
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			depth = n
+			args = args[1:]
+		}
+	}
+	if depth < 1 {
+		c.writeErr(cmd, "Depth must be at least 1")
+		return false
+	}
+
+	root, ok := c.argFolder(cmd, args)
+	if !ok {
+		return false
+	}
+
+	stdio.writeOut(cmd, c.resolvePath(strings.Join(args, "")))
+	return c.walkEntries(root, "", 1, depth, func(entryPath string, entry base.Entry, level int) bool {
+		indent := strings.Repeat("  ", level)
+		stdio.writeOut(cmd, fmt.Sprintf("%s%s\t %s", indent, path.Base(entryPath), render.Summary(entry)))
+		return level < depth
+	})
+}
+
+// stat PATH...
+func (c *rayCtx) cmdStat(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: stat PATH...")
+		return false
+	}
+
+	// gather the known shapes once
+	var shapes []base.Shape
+	if shapeDir, ok := c.ctx.GetFolder(rayShapesPath); ok {
+		for _, name := range shapeDir.Children() {
+			if shape, ok := c.ctx.GetShape(path.Join(rayShapesPath, name)); ok {
+				shapes = append(shapes, shape)
+			}
+		}
+	}
+
+	ok := true
+	for _, arg := range args {
+		target := c.resolvePath(arg)
+		entry, found := c.ctx.Get(target)
+		if !found {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+			ok = false
+			continue
+		}
+
+		stdio.writeOut(cmd, fmt.Sprintf("path: %s", target))
+		stdio.writeOut(cmd, fmt.Sprintf("name: %s", entry.Name()))
+		stdio.writeOut(cmd, fmt.Sprintf("type: %s", render.TypeName(entry)))
+
+		switch entry := entry.(type) {
+		case base.String:
+			stdio.writeOut(cmd, fmt.Sprintf("length: %d", len(entry.Get())))
+		case base.File:
+			stdio.writeOut(cmd, fmt.Sprintf("size: %d", entry.GetSize()))
+		case base.Folder:
+			stdio.writeOut(cmd, fmt.Sprintf("children: %d", len(entry.Children())))
+		case base.Link:
+			stdio.writeOut(cmd, fmt.Sprintf("target: %s", entry.Target()))
+		case base.List:
+			stdio.writeOut(cmd, fmt.Sprintf("length: %d", entry.Len()))
+		}
+
+		var matches []string
+		for _, shape := range shapes {
+			if shape.Check(c.ctx, entry) {
+				matches = append(matches, shape.Name())
+			}
+		}
+		stdio.writeOut(cmd, fmt.Sprintf("shapes: %s", strings.Join(matches, " ")))
+	}
+	return ok
+}
+
+// find [PATH] [-name GLOB] [-type TYPE] [-maxdepth N]
+// PATH itself is depth 0, so -maxdepth 0 only looks at PATH
+func (c *rayCtx) cmdFind(cmd string, args []string, stdio *rayStdio) bool {
+	root := c.cwd.value
+	var nameGlob, typeName string
+	maxDepth := raySearchDepth
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			root = c.resolvePath(arg)
+			continue
+		}
+		if i+1 >= len(args) {
+			c.writeErr(cmd, fmt.Sprintf("Missing value for %s", arg))
+			return false
+		}
+		i++
+		switch arg {
+		case "-name":
+			nameGlob = args[i]
+			if _, err := path.Match(nameGlob, ""); err != nil {
+				c.writeErr(cmd, fmt.Sprintf("Bad pattern %q: %s", nameGlob, err))
+				return false
+			}
+		case "-type":
+			typeName = args[i]
+		case "-maxdepth":
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				c.writeErr(cmd, fmt.Sprintf("Bad depth %q", args[i]))
+				return false
+			}
+			maxDepth = n
+		default:
+			c.writeErr(cmd, fmt.Sprintf("Unknown option %s", arg))
+			return false
+		}
+	}
+
+	folder, ok := c.ctx.GetFolder(root)
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("No such folder %s", root))
+		return false
+	}
+
+	visit := func(entryPath string, entry base.Entry, depth int) bool {
+		matches := true
+		if nameGlob != "" {
+			matches, _ = path.Match(nameGlob, path.Base(entryPath))
+		}
+		if typeName != "" && !strings.EqualFold(render.TypeName(entry), typeName) {
+			matches = false
+		}
+		if matches {
+			stdio.writeOut(cmd, entryPath)
+		}
+		return true
+	}
+
+	// like find(1), the starting folder is depth 0 and can match too
+	visit(root, folder, 0)
+	return c.walkEntries(folder, root, 1, maxDepth, visit)
+}
+
+// grep [-i] PATTERN [PATH...]
+// Searches String entries, going raySearchDepth deep into folders,
+// or else piped text
+// Succeeds only when something matched
+func (c *rayCtx) cmdGrep(cmd string, args []string, stdio *rayStdio) bool {
+	flags, args := splitRayFlags(args)
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: grep [-i] PATTERN [PATH...]")
+		return false
+	}
+
+	pattern := args[0]
+	if flags["i"] {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.writeErr(cmd, fmt.Sprintf("Bad pattern: %s", err))
+		return false
+	}
+
+	var matched bool
+	search := func(label string, text string) {
+		for _, line := range strings.Split(text, "\n") {
+			if re.MatchString(line) {
+				matched = true
+				if label == "" {
+					stdio.writeOut(cmd, line)
+				} else {
+					stdio.writeOut(cmd, fmt.Sprintf("%s: %s", label, line))
+				}
+			}
+		}
+	}
+
+	if len(args) == 1 {
+		str, ok := stdio.input.(base.String)
+		if !ok {
+			c.writeErr(cmd, "Nothing to search, give a path or pipe in text")
+			return false
+		}
+		search("", str.Get())
+		return matched
+	}
+
+	for _, arg := range args[1:] {
+		target := c.resolvePath(arg)
+		entry, ok := c.ctx.Get(target)
+		if !ok {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+			continue
+		}
+
+		switch entry := entry.(type) {
+		case base.String:
+			search(target, entry.Get())
+		case base.Folder:
+			finished := c.walkEntries(entry, target, 1, raySearchDepth, func(entryPath string, sub base.Entry, depth int) bool {
+				if str, ok := sub.(base.String); ok {
+					search(entryPath, str.Get())
+				}
+				return true
+			})
+			if !finished {
+				return false
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,311 @@
+package entries
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Background jobs, started by ending a line with &
+// Each job runs on a copy of the ray, sharing its environ and output
+// but keeping its own working directory and $?
+
+// A command line running off to the side
+type rayJob struct {
+	id      int
+	line    string
+	started time.Time
+	done    chan struct{} // closed once the job's goroutine returns
+	cancel  chan struct{} // closed when the job is killed
+	stop    func()        // interrupts whatever the job is following
+
+	lock  sync.Mutex
+	state string // running, done, failed, killing or killed
+}
+
+func (j *rayJob) getState() string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.state
+}
+
+// Records how the job went, once its goroutine is returning
+// A killed job just becomes killed, since kill already said so
+// The announcement happens under the lock, so it can't land
+// after killAll has returned
+func (j *rayJob) settle(state string, announce func()) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	defer close(j.done)
+
+	if j.state == "killing" {
+		j.state = "killed"
+		return
+	}
+	j.state = state
+	if announce != nil {
+		announce()
+	}
+}
+
+// Cancels the job, which stops before its next command or loop pass
+// A function like kubeRunPodFunc can't be stopped partway, so the job
+// stays killing until that returns, and whatever it prints is dropped
+func (j *rayJob) kill() bool {
+	j.lock.Lock()
+	if j.state != "running" {
+		j.lock.Unlock()
+		return false
+	}
+	j.state = "killing"
+	close(j.cancel)
+	j.lock.Unlock()
+
+	j.stop()
+	return true
+}
+
+// The session's jobs, presented as a folder of job ids
+type rayJobs struct {
+	lock   sync.Mutex
+	jobs   map[int]*rayJob
+	nextID int
+}
+
+var _ base.Folder = (*rayJobs)(nil)
+
+func newRayJobs() *rayJobs {
+	return &rayJobs{
+		jobs:   make(map[int]*rayJob),
+		nextID: 1,
+	}
+}
+
+func (e *rayJobs) Name() string {
+	return "jobs"
+}
+
+func (e *rayJobs) Children() []string {
+	jobs := e.list()
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		names[i] = strconv.Itoa(job.id)
+	}
+	return names
+}
+
+func (e *rayJobs) Fetch(name string) (entry base.Entry, ok bool) {
+	job, ok := e.get(name)
+	if !ok {
+		return nil, false
+	}
+	return inmem.NewFolderOf(name,
+		inmem.NewString("command", job.line),
+		inmem.NewString("state", job.getState()),
+		inmem.NewString("started", job.started.Format(time.RFC3339)),
+	).Freeze(), true
+}
+
+// Jobs are only started from the ray itself
+func (e *rayJobs) Put(name string, entry base.Entry) (ok bool) {
+	return false
+}
+
+func (e *rayJobs) add(line string) *rayJob {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	job := &rayJob{
+		id:      e.nextID,
+		line:    line,
+		started: time.Now(),
+		done:    make(chan struct{}),
+		cancel:  make(chan struct{}),
+		state:   "running",
+	}
+	e.jobs[job.id] = job
+	e.nextID++
+	return job
+}
+
+// Looks up a job by its id, with or without a leading %
+func (e *rayJobs) get(name string) (*rayJob, bool) {
+	if len(name) > 0 && name[0] == '%' {
+		name = name[1:]
+	}
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		return nil, false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	job, ok := e.jobs[id]
+	return job, ok
+}
+
+// Every job, oldest first
+func (e *rayJobs) list() []*rayJob {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	jobs := make([]*rayJob, 0, len(e.jobs))
+	for _, job := range e.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].id < jobs[j].id
+	})
+	return jobs
+}
+
+// Cancels whatever is still running, for when the session ends
+func (e *rayJobs) killAll() {
+	for _, job := range e.list() {
+		job.kill()
+	}
+}
+
+// The session's output as seen by a job
+// Lines are labelled with the job id, apart from errors which keep
+// their own label, and nothing gets through once the job is killed,
+// since the session's Log may be closed by then
+type rayJobOutput struct {
+	base.Log
+	job *rayJob
+}
+
+func (o *rayJobOutput) Append(entry base.Entry) {
+	o.job.lock.Lock()
+	defer o.job.lock.Unlock()
+	if o.job.state == "killing" || o.job.state == "killed" {
+		return
+	}
+
+	if str, ok := entry.(base.String); ok && str.Name() != "error" {
+		entry = inmem.NewString(strconv.Itoa(o.job.id), str.Get())
+	}
+	o.Log.Append(entry)
+}
+
+// Runs a command line in the background and says which job it became
+func (c *rayCtx) startJob(cmd *rayCommand) {
+	job := c.jobs.add(cmd.line)
+	jobCtx := &rayCtx{
+		ctx:        c.ctx,
+		output:     &rayJobOutput{c.sessionOutput(), job},
+		environ:    c.environ,
+		cwd:        c.cwd,
+		status:     c.status,
+		errexit:    c.errexit,
+		interrupts: make(chan struct{}, 1),
+		jobs:       c.jobs,
+		job:        job,
+		cancel:     job.cancel,
+		channels:   c.channels,
+		args:       c.args,
+	}
+	job.stop = jobCtx.interrupt
+
+	c.writeOut("jobs", fmt.Sprintf("[%d] %s", job.id, cmd.line))
+	go func() {
+		state := "failed"
+		defer func() {
+			job.settle(state, func() {
+				c.writeOut("jobs", fmt.Sprintf("[%d] %s\t%s", job.id, state, cmd.line))
+			})
+		}()
+
+		if jobCtx.runChain(cmd.chain) {
+			state = "done"
+		}
+	}()
+}
+
+// Whether this ray is a job that's been killed,
+// in which case it shouldn't start anything else
+func (c *rayCtx) cancelled() bool {
+	select {
+	case <-c.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// jobs
+func (c *rayCtx) cmdJobs(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) > 0 {
+		c.writeErr(cmd, "Too many args")
+		return false
+	}
+	for _, job := range c.jobs.list() {
+		stdio.writeOut(cmd, fmt.Sprintf("[%d] %s\t%s", job.id, job.getState(), job.line))
+	}
+	return true
+}
+
+// wait [ID...]
+// Waits for the given jobs, or all of them, until Ctrl-C
+// Succeeds when every one of them finished well
+func (c *rayCtx) cmdWait(cmd string, args []string, stdio *rayStdio) bool {
+	var jobs []*rayJob
+	if len(args) == 0 {
+		// a job waiting on every job shouldn't wait on itself
+		for _, job := range c.jobs.list() {
+			if job != c.job {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+	for _, arg := range args {
+		job, ok := c.jobs.get(arg)
+		if !ok {
+			c.writeErr(cmd, fmt.Sprintf("No such job %s", arg))
+			return false
+		}
+		jobs = append(jobs, job)
+	}
+
+	ok := true
+	for _, job := range jobs {
+		if c.waitOrInterrupt(job.done) {
+			c.writeErr(cmd, "Interrupted")
+			return false
+		}
+		if job.getState() != "done" {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// kill ID...
+func (c *rayCtx) cmdKill(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: kill ID...")
+		return false
+	}
+
+	ok := true
+	for _, arg := range args {
+		job, found := c.jobs.get(arg)
+		if !found {
+			c.writeErr(cmd, fmt.Sprintf("No such job %s", arg))
+			ok = false
+			continue
+		}
+		if !job.kill() {
+			c.writeErr(cmd, fmt.Sprintf("Job %d already %s", job.id, job.getState()))
+			ok = false
+			continue
+		}
+		stdio.writeOut(cmd, fmt.Sprintf("[%d] killed\t%s", job.id, job.line))
+	}
+	return ok
+}
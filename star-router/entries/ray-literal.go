@@ -0,0 +1,107 @@
+package entries
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// An inline Folder of Strings, written like {name=build image=golang}
+// Values can be quoted or use variables, and braces nest for subfolders
+type rayInputLiteral struct {
+	fields []rayLiteralField
+}
+
+type rayLiteralField struct {
+	key    string
+	value  rayWord          // for Strings
+	folder *rayInputLiteral // for subfolders
+}
+
+// Reads a literal starting at the { token
+// Returns the index just past its closing }
+func parseRayInputLiteral(tokens []rayToken, start int) (lit *rayInputLiteral, next int, err error) {
+	lit = &rayInputLiteral{}
+	seen := make(map[string]bool)
+
+	for i := start + 1; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.op {
+		case "}":
+			return lit, i + 1, nil
+		case "":
+		default:
+			return nil, 0, fmt.Errorf("unexpected %s inside {}", tok.op)
+		}
+
+		key, value, ok := splitRayLiteralField(tok.word)
+		if !ok {
+			return nil, 0, errors.New("expected key=value inside {}")
+		}
+		if seen[key] {
+			return nil, 0, fmt.Errorf("%s is given twice inside {}", key)
+		}
+		seen[key] = true
+
+		// key= right before a brace starts a subfolder
+		if len(value) == 0 && i+1 < len(tokens) && tokens[i+1].op == "{" {
+			sub, subNext, err := parseRayInputLiteral(tokens, i+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			lit.fields = append(lit.fields, rayLiteralField{key: key, folder: sub})
+			i = subNext - 1
+			continue
+		}
+		lit.fields = append(lit.fields, rayLiteralField{key: key, value: value})
+	}
+	return nil, 0, errors.New("missing } to close {")
+}
+
+// Splits a word at its first unquoted-looking =, which has to come
+// before any variables so that keys are always plain text
+func splitRayLiteralField(word rayWord) (key string, value rayWord, ok bool) {
+	if len(word) == 0 || word[0].variable || word[0].literal != nil {
+		return
+	}
+	idx := strings.Index(word[0].text, "=")
+	if idx < 1 {
+		return
+	}
+
+	key = word[0].text[:idx]
+	if rest := word[0].text[idx+1:]; rest != "" {
+		value = append(value, rayWordPart{text: rest})
+	}
+	value = append(value, word[1:]...)
+	return key, value, true
+}
+
+// Builds the Folder, expanding variables as it goes
+func (l *rayInputLiteral) build(name string, lookup func(string) string) base.Folder {
+	folder := inmem.NewFolder(name)
+	for _, field := range l.fields {
+		if field.folder != nil {
+			folder.Put(field.key, field.folder.build(field.key, lookup))
+		} else {
+			folder.Put(field.key, inmem.NewString(field.key, field.value.expand(lookup)))
+		}
+	}
+	return folder
+}
+
+// How the literal reads back, for messages
+func (l *rayInputLiteral) String() string {
+	parts := make([]string, len(l.fields))
+	for i, field := range l.fields {
+		if field.folder != nil {
+			parts[i] = field.key + "=" + field.folder.String()
+		} else {
+			parts[i] = field.key + "=..."
+		}
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
@@ -0,0 +1,336 @@
+package entries
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// One piece of a word, either literal text or a variable to look up
+// A whole {key=value} input literal is kept as a single part
+type rayWordPart struct {
+	text     string
+	variable bool
+	literal  *rayInputLiteral
+}
+
+// A shell word as written, before variables are expanded
+// Expansion waits until the command runs, so loops see fresh values
+type rayWord []rayWordPart
+
+// Either a word or an unquoted operator such as | or &&
+type rayToken struct {
+	op   string
+	word rayWord
+}
+
+// Operators, longest first so that >> wins over >
+// Braces are only operators where they can open or close a literal,
+// see lexRayLine
+var rayOperators = []string{">>", "&&", "||", ">", "|", "&", "{", "}"}
+
+// Splits a line into words and operators the way a shell would,
+// honoring quotes and backslashes and noting $NAME and ${NAME} references
+// Single quotes keep their contents literal, double quotes still expand
+func lexRayLine(line string) (tokens []rayToken, err error) {
+	var word rayWord
+	var buf strings.Builder
+	inWord := false
+	depth := 0 // literals left open
+
+	flushText := func() {
+		if buf.Len() > 0 {
+			word = append(word, rayWordPart{text: buf.String()})
+			buf.Reset()
+		}
+	}
+	flushWord := func() {
+		flushText()
+		if inWord {
+			tokens = append(tokens, rayToken{word: word})
+		}
+		word = nil
+		inWord = false
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		op := rayOperatorAt(runes, i)
+		switch op {
+		case "{":
+			// a brace inside a word is just text, like a{b},
+			// unless it opens a subfolder right after key=
+			if inWord && !(depth > 0 && runes[i-1] == '=') {
+				op = ""
+			}
+		case "}":
+			if depth == 0 {
+				op = ""
+			}
+		}
+
+		switch {
+
+		case r == ' ' || r == '\t':
+			flushWord()
+
+		case op != "":
+			flushWord()
+			switch op {
+			case "{":
+				depth++
+			case "}":
+				depth--
+			}
+			tokens = append(tokens, rayToken{op: op})
+			i += len([]rune(op)) - 1
+
+		case r == '\\':
+			inWord = true
+			if i+1 < len(runes) {
+				i++
+				buf.WriteRune(runes[i])
+			}
+
+		case r == '\'':
+			inWord = true
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			buf.WriteString(string(runes[i+1 : end]))
+			i = end
+
+		case r == '"':
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, errors.New("unterminated double quote")
+				}
+				if runes[i] == '"' {
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`, runes[i+1]) {
+					i++
+					buf.WriteRune(runes[i])
+					continue
+				}
+				if runes[i] == '$' {
+					if name, next, ok := lexRayVariable(runes, i); ok {
+						flushText()
+						word = append(word, rayWordPart{text: name, variable: true})
+						i = next - 1
+						continue
+					}
+				}
+				buf.WriteRune(runes[i])
+			}
+
+		case r == '$':
+			inWord = true
+			name, next, ok := lexRayVariable(runes, i)
+			if !ok {
+				if next < 0 {
+					return nil, fmt.Errorf("unterminated ${ at column %d", i+1)
+				}
+				buf.WriteRune(r)
+				continue
+			}
+			flushText()
+			word = append(word, rayWordPart{text: name, variable: true})
+			i = next - 1
+
+		default:
+			inWord = true
+			buf.WriteRune(r)
+		}
+	}
+	flushWord()
+	return
+}
+
+// Finds the operator starting at a position, if any
+func rayOperatorAt(runes []rune, i int) string {
+	rest := string(runes[i:])
+	for _, op := range rayOperators {
+		if strings.HasPrefix(rest, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// Reads a variable reference starting at the $
+// Returns the name and the index just past the reference
+// A bare $ isn't a reference, and next is -1 if a ${ is never closed
+func lexRayVariable(runes []rune, start int) (name string, next int, ok bool) {
+	i := start + 1
+	if i < len(runes) && runes[i] == '{' {
+		end := indexRune(runes, i+1, '}')
+		if end < 0 {
+			return "", -1, false
+		}
+		if end == i+1 {
+			return "", end + 1, false
+		}
+		return string(runes[i+1 : end]), end + 1, true
+	}
+	if i < len(runes) && runes[i] == '?' {
+		// status of the last command
+		return "?", i + 1, true
+	}
+	if i < len(runes) && runes[i] >= '0' && runes[i] <= '9' {
+		// script arguments, one digit at a time like sh
+		return string(runes[i]), i + 1, true
+	}
+
+	for i < len(runes) && isRayNameRune(runes[i], i == start+1) {
+		i++
+	}
+	if i == start+1 {
+		return "", i, false
+	}
+	return string(runes[start+1 : i]), i, true
+}
+
+func isRayNameRune(r rune, first bool) bool {
+	switch {
+	case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return true
+	case r >= '0' && r <= '9':
+		return !first
+	default:
+		return false
+	}
+}
+
+func isRayName(name string) bool {
+	for i, r := range name {
+		if !isRayNameRune(r, i == 0) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func indexRune(runes []rune, from int, target rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == target {
+			return i
+		}
+	}
+	return -1
+}
+
+// A series of commands, each fed the output of the one before
+// The last one's output can be sent to a path instead of the log
+type rayPipeline struct {
+	stages   [][]rayWord
+	redirect rayWord // path to store output at, if any
+	appends  bool    // add to the existing String instead of replacing
+}
+
+// Pipelines joined by && or ||, where each join decides
+// whether the next pipeline runs based on how the last one went
+type rayChain struct {
+	pipes []*rayPipeline
+	joins []string // between each pair of pipes
+}
+
+// Splits a line's tokens on && and || into pipelines
+func parseRayChain(tokens []rayToken) (*rayChain, error) {
+	chain := &rayChain{}
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && tokens[i].op != "&&" && tokens[i].op != "||" {
+			continue
+		}
+
+		pipe, err := parseRayPipeline(tokens[start:i])
+		if err != nil {
+			return nil, err
+		}
+		if i < len(tokens) {
+			if len(pipe.stages) == 0 {
+				return nil, fmt.Errorf("missing command before %s", tokens[i].op)
+			}
+			chain.joins = append(chain.joins, tokens[i].op)
+		} else if len(pipe.stages) == 0 && len(chain.joins) > 0 {
+			return nil, fmt.Errorf("missing command after %s", chain.joins[len(chain.joins)-1])
+		}
+		chain.pipes = append(chain.pipes, pipe)
+		start = i + 1
+	}
+	return chain, nil
+}
+
+// Groups a line's tokens into a pipeline
+// Redirection is only allowed at the end of the line
+func parseRayPipeline(tokens []rayToken) (*rayPipeline, error) {
+	pipe := &rayPipeline{}
+	var stage []rayWord
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.op {
+
+		case "":
+			stage = append(stage, tok.word)
+
+		case "{":
+			lit, next, err := parseRayInputLiteral(tokens, i)
+			if err != nil {
+				return nil, err
+			}
+			stage = append(stage, rayWord{{literal: lit}})
+			i = next - 1
+
+		case "|":
+			if len(stage) == 0 {
+				return nil, errors.New("missing command before |")
+			}
+			pipe.stages = append(pipe.stages, stage)
+			stage = nil
+
+		case ">", ">>":
+			if i+1 >= len(tokens) || tokens[i+1].op != "" {
+				return nil, fmt.Errorf("missing path after %s", tok.op)
+			}
+			if i+2 != len(tokens) {
+				return nil, fmt.Errorf("%s must come at the end of the line", tok.op)
+			}
+			pipe.redirect = tokens[i+1].word
+			pipe.appends = tok.op == ">>"
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected %s", tok.op)
+		}
+	}
+
+	if len(stage) == 0 {
+		if len(pipe.stages) > 0 || pipe.redirect != nil {
+			return nil, errors.New("missing command")
+		}
+		return pipe, nil
+	}
+	pipe.stages = append(pipe.stages, stage)
+	return pipe, nil
+}
+
+// Fills in variables using the lookup, with unknown names becoming empty
+func (w rayWord) expand(lookup func(name string) string) string {
+	var buf strings.Builder
+	for _, part := range w {
+		if part.literal != nil {
+			buf.WriteString(part.literal.String())
+		} else if part.variable {
+			buf.WriteString(lookup(part.text))
+		} else {
+			buf.WriteString(part.text)
+		}
+	}
+	return buf.String()
+}
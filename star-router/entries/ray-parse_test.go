@@ -0,0 +1,355 @@
+package entries
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes a word back out with variables as ${NAME}
+func describeRayWord(word rayWord) string {
+	var buf strings.Builder
+	for _, part := range word {
+		switch {
+		case part.literal != nil:
+			buf.WriteString(part.literal.String())
+		case part.variable:
+			buf.WriteString("${" + part.text + "}")
+		default:
+			buf.WriteString(part.text)
+		}
+	}
+	return buf.String()
+}
+
+// Writes tokens out one per string, with operators as <OP>
+func describeRayTokens(tokens []rayToken) []string {
+	described := make([]string, len(tokens))
+	for i, tok := range tokens {
+		if tok.op != "" {
+			described[i] = "<" + tok.op + ">"
+		} else {
+			described[i] = describeRayWord(tok.word)
+		}
+	}
+	return described
+}
+
+func describeRayChain(chain *rayChain) string {
+	var buf strings.Builder
+	for i, pipe := range chain.pipes {
+		if i > 0 {
+			buf.WriteString(" " + chain.joins[i-1] + " ")
+		}
+		for j, stage := range pipe.stages {
+			if j > 0 {
+				buf.WriteString(" | ")
+			}
+			words := make([]string, len(stage))
+			for k, word := range stage {
+				words[k] = describeRayWord(word)
+			}
+			buf.WriteString(strings.Join(words, " "))
+		}
+		if pipe.redirect != nil {
+			op := ">"
+			if pipe.appends {
+				op = ">>"
+			}
+			buf.WriteString(" " + op + " " + describeRayWord(pipe.redirect))
+		}
+	}
+	return buf.String()
+}
+
+func describeRayCondition(cond rayCondition) string {
+	if cond.negate {
+		return "! " + describeRayChain(cond.cmd.chain)
+	}
+	return describeRayChain(cond.cmd.chain)
+}
+
+// Writes nodes out on one line, with blocks in braces
+func describeRayNodes(nodes []rayNode) string {
+	described := make([]string, len(nodes))
+	for i, node := range nodes {
+		switch node := node.(type) {
+		case *rayCommand:
+			described[i] = describeRayChain(node.chain)
+			if node.background {
+				described[i] += " &"
+			}
+		case *rayIf:
+			described[i] = "if " + describeRayCondition(node.cond) + " { " + describeRayNodes(node.then) + " }"
+			if len(node.orElse) > 0 {
+				described[i] += " else { " + describeRayNodes(node.orElse) + " }"
+			}
+		case *rayWhile:
+			described[i] = "while " + describeRayCondition(node.cond) + " { " + describeRayNodes(node.body) + " }"
+		case *rayFor:
+			described[i] = "for " + node.varName + " in " + describeRayWord(node.folder) + " { " + describeRayNodes(node.body) + " }"
+		}
+	}
+	return strings.Join(described, "; ")
+}
+
+func TestLexRayLine(t *testing.T) {
+	cases := []struct {
+		name   string
+		line   string
+		tokens []string
+		err    string
+	}{
+		{
+			name:   "words",
+			line:   "ls  /n/init\t-l ",
+			tokens: []string{"ls", "/n/init", "-l"},
+		},
+		{
+			name:   "empty",
+			line:   " \t ",
+			tokens: []string{},
+		},
+		{
+			name:   "single quotes keep everything",
+			line:   `echo 'a  b $X \n'`,
+			tokens: []string{"echo", `a  b $X \n`},
+		},
+		{
+			name:   "double quotes expand",
+			line:   `echo "hi $USER!" "${A}b"`,
+			tokens: []string{"echo", "hi ${USER}!", "${A}b"},
+		},
+		{
+			name:   "quotes join onto words",
+			line:   `echo a'b c'"d"`,
+			tokens: []string{"echo", "ab cd"},
+		},
+		{
+			name:   "empty quotes are still a word",
+			line:   `echo '' ""`,
+			tokens: []string{"echo", "", ""},
+		},
+		{
+			name:   "escapes",
+			line:   `echo a\ b \$X \| "q\"x\\" "\n"`,
+			tokens: []string{"echo", "a b", "$X", "|", `q"x\`, `\n`},
+		},
+		{
+			name:   "variables",
+			line:   "echo $A-$B_1 $? $12 ${weird.name}",
+			tokens: []string{"echo", "${A}-${B_1}", "${?}", "${1}2", "${weird.name}"},
+		},
+		{
+			name:   "bare dollars",
+			line:   "echo $ a$ ${}",
+			tokens: []string{"echo", "$", "a$", "${}"},
+		},
+		{
+			name:   "redirects",
+			line:   "cat a>b >>c",
+			tokens: []string{"cat", "a", "<>>", "b", "<>>>", "c"},
+		},
+		{
+			name:   "joins and pipes without spaces",
+			line:   "a&&b||c|d&",
+			tokens: []string{"a", "<&&>", "b", "<||>", "c", "<|>", "d", "<&>"},
+		},
+		{
+			name:   "quoted operators are words",
+			line:   `echo '|' "&&" '>'`,
+			tokens: []string{"echo", "|", "&&", ">"},
+		},
+		{
+			name:   "literals",
+			line:   "invoke /f {a=b sub={c=$D}}",
+			tokens: []string{"invoke", "/f", "<{>", "a=b", "sub=", "<{>", "c=${D}", "<}>", "<}>"},
+		},
+		{
+			name:   "braces inside words are text",
+			line:   "echo a{b} x={y} c}",
+			tokens: []string{"echo", "a{b}", "x={y}", "c}"},
+		},
+		{
+			name:   "closing brace without a literal is text",
+			line:   "echo } {a=}}",
+			tokens: []string{"echo", "}", "<{>", "a=", "<}>", "}"},
+		},
+		{
+			name:   "quoted braces",
+			line:   `echo '{' "}"`,
+			tokens: []string{"echo", "{", "}"},
+		},
+		{
+			name: "unterminated single quote",
+			line: "echo 'abc",
+			err:  "unterminated single quote",
+		},
+		{
+			name: "unterminated double quote",
+			line: `echo "abc\"`,
+			err:  "unterminated double quote",
+		},
+		{
+			name: "unterminated variable",
+			line: "echo ${A",
+			err:  "unterminated ${ at column 6",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := lexRayLine(tc.line)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("lexRayLine(%q) error = %v, want %q", tc.line, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lexRayLine(%q) failed: %s", tc.line, err)
+			}
+			if got := describeRayTokens(tokens); !reflect.DeepEqual(got, tc.tokens) {
+				t.Errorf("lexRayLine(%q) = %q, want %q", tc.line, got, tc.tokens)
+			}
+		})
+	}
+}
+
+func TestParseRayScript(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		nodes  string
+		err    string
+	}{
+		{
+			name:   "lines",
+			script: "echo hi\n\nls /n",
+			nodes:  "echo hi; ls /n",
+		},
+		{
+			name:   "comments and header",
+			script: "#!ray\n  # indented note\necho hi # not a comment",
+			nodes:  "echo hi # not a comment",
+		},
+		{
+			name:   "pipes and redirects",
+			script: "ls /n | grep x > /tmp/out\necho $? >> /tmp/log",
+			nodes:  "ls /n | grep x > /tmp/out; echo ${?} >> /tmp/log",
+		},
+		{
+			name:   "chains",
+			script: "test -e /a && echo yes || echo no",
+			nodes:  "test -e /a && echo yes || echo no",
+		},
+		{
+			name:   "background",
+			script: "tail -f /n/log &\njobs",
+			nodes:  "tail -f /n/log &; jobs",
+		},
+		{
+			name:   "literals",
+			script: "invoke /f {a=$X sub={b=c}} > /tmp/out",
+			nodes:  "invoke /f {a=... sub={b=...}} > /tmp/out",
+		},
+		{
+			name:   "if and else",
+			script: "if test -e /a\n  echo yes\nelse\n  echo no\nend",
+			nodes:  "if test -e /a { echo yes } else { echo no }",
+		},
+		{
+			name:   "nested blocks",
+			script: "for x in /n\n  if ! test -d $x\n    echo $x\n  else\n    while test -e /a\n      rm /a\n    end\n  end\nend\necho done",
+			nodes:  "for x in /n { if ! test -d ${x} { echo ${x} } else { while test -e /a { rm /a } } }; echo done",
+		},
+		{
+			name:   "keywords only count at the start",
+			script: "if test $x = end\n  echo else\nend",
+			nodes:  "if test ${x} = end { echo else }",
+		},
+		{
+			name:   "unterminated block",
+			script: "while test -e /a\n  if test -e /b\n  end",
+			err:    "missing `end` at end of script",
+		},
+		{
+			name:   "stray end",
+			script: "echo hi\nend",
+			err:    "line 2: end without a matching block",
+		},
+		{
+			name:   "stray else",
+			script: "for x in /n\nelse\nend",
+			err:    "line 2: else without a matching if",
+		},
+		{
+			name:   "second else",
+			script: "if test\nelse\nelse\nend",
+			err:    "line 3: else without a matching if",
+		},
+		{
+			name:   "bad for",
+			script: "for x /n\nend",
+			err:    "line 1: for: expected `for NAME in FOLDER`",
+		},
+		{
+			name:   "bad for name",
+			script: "for 1x in /n\nend",
+			err:    `line 1: for: "1x" isn't a valid variable name`,
+		},
+		{
+			name:   "missing condition",
+			script: "if\nend",
+			err:    "line 1: if: missing condition",
+		},
+		{
+			name:   "unterminated quote inside a block",
+			script: "if test\n  echo 'abc\nend",
+			err:    "line 2: unterminated single quote",
+		},
+		{
+			name:   "unterminated literal",
+			script: "invoke /f {a=b",
+			err:    "line 1: missing } to close {",
+		},
+		{
+			name:   "redirect before the end",
+			script: "ls > /tmp/out | cat",
+			err:    "line 1: > must come at the end of the line",
+		},
+		{
+			name:   "missing command after a join",
+			script: "ls &&",
+			err:    "line 1: missing command after &&",
+		},
+		{
+			name:   "missing command before a pipe",
+			script: "| cat",
+			err:    "line 1: missing command before |",
+		},
+		{
+			name:   "lone ampersand",
+			script: "&",
+			err:    "line 1: missing command before &",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes, err := parseRayScript(tc.script)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("parseRayScript(%q) error = %v, want %q", tc.script, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRayScript(%q) failed: %s", tc.script, err)
+			}
+			if got := describeRayNodes(nodes); got != tc.nodes {
+				t.Errorf("parseRayScript(%q) = %q, want %q", tc.script, got, tc.nodes)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package entries
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/danopia/stardust/star-router/render"
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Where a command reads piped input from and sends its output to
+type rayStdio struct {
+	ray      *rayCtx
+	input    base.Entry         // from the previous command in a pipeline
+	capture  *rayCapture        // nil when output goes to the session log
+	literals map[int]base.Entry // {key=value} folders, by argument index
+}
+
+// The folder given inline for an argument, if any
+func (s *rayStdio) literal(idx int) (base.Folder, bool) {
+	folder, ok := s.literals[idx].(base.Folder)
+	return folder, ok
+}
+
+// Output held back for the next command or a redirect
+type rayCapture struct {
+	lines []string
+	value base.Entry // set when a command produces a whole entry
+}
+
+func (s *rayStdio) writeOut(label, line string) {
+	if s.capture != nil {
+		s.capture.lines = append(s.capture.lines, line)
+	} else {
+		s.ray.writeOut(label, line)
+	}
+}
+
+// Passes along an entry as-is when capturing,
+// otherwise prints what it can of it
+func (s *rayStdio) emit(label string, entry base.Entry) {
+	if s.capture != nil && len(s.capture.lines) == 0 {
+		s.capture.value = entry
+		return
+	}
+
+	if str, ok := entry.(base.String); ok {
+		s.writeOut(label, fmt.Sprintf("=> %s", str.Get()))
+		return
+	}
+	for _, line := range render.Text(entry, render.DefaultOptions) {
+		s.writeOut(label, line)
+	}
+}
+
+// The session log as seen by a script whose output is captured,
+// as in `myscript | grep x` or `source setup > /tmp/out`
+// Lines go into the capture, while errors still go to the log
+type rayCaptureOutput struct {
+	base.Log
+	capture *rayCapture
+}
+
+func (o *rayCaptureOutput) Append(entry base.Entry) {
+	str, isString := entry.(base.String)
+	switch {
+	case isString && str.Name() == "error":
+		o.Log.Append(entry)
+	case isString:
+		o.capture.lines = append(o.capture.lines, str.Get())
+	default:
+		o.capture.lines = append(o.capture.lines, render.Text(entry, render.DefaultOptions)...)
+	}
+}
+
+// Where a script run as this command should send its output
+func (s *rayStdio) scriptOutput() base.Log {
+	if s.capture == nil {
+		return s.ray.output
+	}
+	return &rayCaptureOutput{s.ray.output, s.capture}
+}
+
+// The Log that output finally lands in, past any captures
+// and job labelling it goes through on the way
+func (c *rayCtx) sessionOutput() base.Log {
+	output := c.output
+	for {
+		switch wrapper := output.(type) {
+		case *rayCaptureOutput:
+			output = wrapper.Log
+		case *rayJobOutput:
+			output = wrapper.Log
+		default:
+			return output
+		}
+	}
+}
+
+// Turns captured output into an entry
+// Whole entries pass through, and lines become one String
+func (c *rayCapture) entry(name string) base.Entry {
+	if c.value != nil && len(c.lines) == 0 {
+		return c.value
+	}
+	return inmem.NewString(name, strings.Join(c.lines, "\n"))
+}
+
+// Runs each stage with the previous stage's output as input,
+// then stores or prints what the last stage produced
+func (c *rayCtx) runPipeline(pipe *rayPipeline) (ok bool) {
+	if c.cancelled() || c.interrupted() {
+		return false
+	}
+
+	var input base.Entry
+	for i, stage := range pipe.stages {
+		parts := c.expandWords(stage)
+		stdio := &rayStdio{ray: c, input: input, literals: c.buildLiterals(stage)}
+
+		last := i == len(pipe.stages)-1
+		if !last || pipe.redirect != nil {
+			stdio.capture = &rayCapture{}
+		}
+
+		if !c.evalSafely(parts[0], parts[1:], stdio) {
+			return false
+		}
+		if stdio.capture != nil {
+			input = stdio.capture.entry("output")
+		}
+	}
+
+	if pipe.redirect == nil {
+		return true
+	}
+	target := path.Join(c.cwd.value, pipe.redirect.expand(c.getVar))
+	return c.storeOutput(target, input, pipe.appends)
+}
+
+// Builds the input literals among a command's arguments
+func (c *rayCtx) buildLiterals(stage []rayWord) map[int]base.Entry {
+	var literals map[int]base.Entry
+	for i, word := range stage[1:] {
+		if len(word) == 1 && word[0].literal != nil {
+			if literals == nil {
+				literals = make(map[int]base.Entry)
+			}
+			literals[i] = word[0].literal.build("input", c.getVar)
+		}
+	}
+	return literals
+}
+
+// Some drivers panic when their backend has trouble,
+// which shouldn't take the whole router down with the command
+func (c *rayCtx) evalSafely(cmd string, args []string, stdio *rayStdio) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Ray command", cmd, "panicked:", r)
+			c.writeErr(cmd, fmt.Sprintf("Panicked: %v", r))
+			ok = false
+		}
+	}()
+	return c.evalCommand(cmd, args, stdio)
+}
+
+// Writes a pipeline's output to a path, optionally after what's there
+func (c *rayCtx) storeOutput(target string, output base.Entry, appends bool) bool {
+	name := path.Base(target)
+
+	if appends {
+		str, ok := output.(base.String)
+		if !ok {
+			c.writeErr(">>", "Only text output can be appended")
+			return false
+		}
+		text := str.Get()
+
+		if existing, ok := c.ctx.Get(target); ok {
+			prev, ok := existing.(base.String)
+			if !ok {
+				c.writeErr(">>", fmt.Sprintf("%s isn't a String, can't append", target))
+				return false
+			}
+			if prev.Get() != "" {
+				text = prev.Get() + "\n" + text
+			}
+		}
+		output = inmem.NewString(name, text)
+
+	} else if str, ok := output.(base.String); ok {
+		// take on the name of the destination
+		output = inmem.NewString(name, str.Get())
+	}
+
+	if !c.ctx.Put(target, output) {
+		c.writeErr(">", fmt.Sprintf("Couldn't write output to %s", target))
+		return false
+	}
+	return true
+}
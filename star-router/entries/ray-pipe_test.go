@@ -0,0 +1,94 @@
+package entries
+
+import (
+	"testing"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// A namespace of loose entries by path, enough for scripts and redirects
+// Anything else a test reaches for panics on the nil Context
+type testRayContext struct {
+	base.Context
+	entries map[string]base.Entry
+}
+
+func (c *testRayContext) Get(path string) (base.Entry, bool) {
+	entry, ok := c.entries[path]
+	return entry, ok
+}
+
+func (c *testRayContext) Put(path string, entry base.Entry) bool {
+	c.entries[path] = entry
+	return true
+}
+
+func TestRayPipelineCapturesScripts(t *testing.T) {
+	greet := "#!ray\necho hello\necho world"
+
+	cases := []struct {
+		name string
+		line string
+		out  string
+	}{
+		{
+			name: "script redirected",
+			line: "greet > /out",
+			out:  "hello\nworld",
+		},
+		{
+			name: "script piped",
+			line: "greet | grep wor > /out",
+			out:  "world",
+		},
+		{
+			name: "script by path",
+			line: "/boot/bin/greet | grep hel > /out",
+			out:  "hello",
+		},
+		{
+			name: "sourced script redirected",
+			line: "source /boot/bin/greet > /out",
+			out:  "hello\nworld",
+		},
+		{
+			name: "nested scripts",
+			line: "twice > /out",
+			out:  "hello\nworld\nhello\nworld",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &testRayContext{entries: map[string]base.Entry{
+				"/boot/bin/greet": inmem.NewString("greet", greet),
+				"/boot/bin/twice": inmem.NewString("twice", "#!ray\ngreet\ngreet"),
+			}}
+			c := newRayCtx(ctx)
+
+			nodes, err := parseRayScript(tc.line)
+			if err != nil {
+				t.Fatalf("parseRayScript(%q) failed: %s", tc.line, err)
+			}
+			if !c.runAsCommand(nodes) {
+				t.Fatalf("%q failed with status %d", tc.line, c.status)
+			}
+
+			entry, ok := ctx.entries["/out"]
+			if !ok {
+				t.Fatalf("%q didn't write /out", tc.line)
+			}
+			str, ok := entry.(base.String)
+			if !ok {
+				t.Fatalf("%q wrote a %T to /out", tc.line, entry)
+			}
+			if str.Get() != tc.out {
+				t.Errorf("%q wrote %q, want %q", tc.line, str.Get(), tc.out)
+			}
+			if c.output != c.sessionOutput() {
+				t.Errorf("%q left the session's output wrapped", tc.line)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package entries
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Something runnable in a ray script, either a command line or a block
+type rayNode interface{}
+
+// A single line of commands
+type rayCommand struct {
+	line       string
+	chain      *rayChain
+	background bool // ended with &, so it runs as a job
+}
+
+// A condition is a command line, true when it succeeds
+// Starting the line with ! flips the outcome
+type rayCondition struct {
+	negate bool
+	cmd    *rayCommand
+}
+
+// if COND ... [else ...] end
+type rayIf struct {
+	cond   rayCondition
+	then   []rayNode
+	orElse []rayNode
+	inElse bool // parser state, whether lines go to orElse
+}
+
+// for NAME in FOLDER ... end
+type rayFor struct {
+	varName string
+	folder  rayWord
+	body    []rayNode
+}
+
+// while COND ... end
+type rayWhile struct {
+	cond rayCondition
+	body []rayNode
+}
+
+// Assembles lines into nodes, holding lines back while a block is open
+// Used both for whole scripts and for lines typed one at a time
+type rayParser struct {
+	stack []rayNode // open blocks, innermost last
+}
+
+// Takes one line, returning a node once it's complete
+// Returns nil while a block is still open
+// Lines starting with # are comments, which covers the #!ray header
+func (p *rayParser) feed(line string) (node rayNode, err error) {
+	if strings.HasPrefix(strings.TrimLeft(line, " \t"), "#") {
+		return nil, nil
+	}
+
+	tokens, err := lexRayLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	switch rayKeyword(tokens[0]) {
+
+	case "if":
+		cond, err := parseRayCondition(line, tokens[1:])
+		if err != nil {
+			return nil, fmt.Errorf("if: %s", err)
+		}
+		p.stack = append(p.stack, &rayIf{cond: cond})
+		return nil, nil
+
+	case "while":
+		cond, err := parseRayCondition(line, tokens[1:])
+		if err != nil {
+			return nil, fmt.Errorf("while: %s", err)
+		}
+		p.stack = append(p.stack, &rayWhile{cond: cond})
+		return nil, nil
+
+	case "for":
+		if len(tokens) != 4 || tokens[1].op != "" || rayKeyword(tokens[2]) != "in" || tokens[3].op != "" {
+			return nil, errors.New("for: expected `for NAME in FOLDER`")
+		}
+		name := rayKeyword(tokens[1])
+		if !isRayName(name) {
+			return nil, fmt.Errorf("for: %q isn't a valid variable name", name)
+		}
+		p.stack = append(p.stack, &rayFor{varName: name, folder: tokens[3].word})
+		return nil, nil
+
+	case "else":
+		if len(tokens) != 1 {
+			return nil, errors.New("else: unexpected arguments")
+		}
+		block, ok := p.top().(*rayIf)
+		if !ok || block.inElse {
+			return nil, errors.New("else without a matching if")
+		}
+		block.inElse = true
+		return nil, nil
+
+	case "end":
+		if len(tokens) != 1 {
+			return nil, errors.New("end: unexpected arguments")
+		}
+		if len(p.stack) == 0 {
+			return nil, errors.New("end without a matching block")
+		}
+		node = p.stack[len(p.stack)-1]
+		p.stack = p.stack[:len(p.stack)-1]
+
+	default:
+		background := tokens[len(tokens)-1].op == "&"
+		if background {
+			tokens = tokens[:len(tokens)-1]
+			if len(tokens) == 0 {
+				return nil, errors.New("missing command before &")
+			}
+		}
+
+		chain, err := parseRayChain(tokens)
+		if err != nil {
+			return nil, err
+		}
+		node = &rayCommand{line, chain, background}
+	}
+
+	// nest completed nodes in their enclosing block
+	switch block := p.top().(type) {
+	case nil:
+		return node, nil
+	case *rayIf:
+		if block.inElse {
+			block.orElse = append(block.orElse, node)
+		} else {
+			block.then = append(block.then, node)
+		}
+	case *rayFor:
+		block.body = append(block.body, node)
+	case *rayWhile:
+		block.body = append(block.body, node)
+	}
+	return nil, nil
+}
+
+func (p *rayParser) top() rayNode {
+	if len(p.stack) == 0 {
+		return nil
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+// Whether a block is still waiting for its end
+func (p *rayParser) pending() bool {
+	return len(p.stack) > 0
+}
+
+// Throws away any half-typed blocks
+func (p *rayParser) reset() {
+	p.stack = nil
+}
+
+// Parses a whole script up front, so mistakes are caught before anything runs
+func parseRayScript(script string) (nodes []rayNode, err error) {
+	var parser rayParser
+	for num, raw := range strings.Split(script, "\n") {
+		line := strings.Trim(raw, " \t")
+		node, err := parser.feed(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", num+1, err)
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if parser.pending() {
+		return nil, errors.New("missing `end` at end of script")
+	}
+	return nodes, nil
+}
+
+func parseRayCondition(line string, tokens []rayToken) (cond rayCondition, err error) {
+	if len(tokens) > 0 && rayKeyword(tokens[0]) == "!" {
+		cond.negate = true
+		tokens = tokens[1:]
+	}
+
+	chain, err := parseRayChain(tokens)
+	if err != nil {
+		return
+	}
+	if len(chain.pipes[0].stages) == 0 {
+		err = errors.New("missing condition")
+		return
+	}
+	cond.cmd = &rayCommand{line, chain, false}
+	return
+}
+
+// The literal text of a bare word, or empty for anything else
+// Keywords only count when they're written plainly
+func rayKeyword(tok rayToken) string {
+	if tok.op != "" || len(tok.word) != 1 || tok.word[0].variable {
+		return ""
+	}
+	return tok.word[0].text
+}
+
+// Runs nodes in order, unless one says to stop
+// or Ctrl-C comes in between them
+func (c *rayCtx) runNodes(nodes []rayNode) (ok bool) {
+	for _, node := range nodes {
+		if c.stopIfInterrupted() || !c.runNode(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sets $? for an interrupt or kill, if one came in, so the caller can stop
+func (c *rayCtx) stopIfInterrupted() bool {
+	if !c.cancelled() && !c.interrupted() {
+		return false
+	}
+	c.status = rayInterruptedStatus
+	return true
+}
+
+// Runs a node and records its status in $?
+// Returns false only when the ray should stop, which is
+// when a command fails after `set -e` or is interrupted
+func (c *rayCtx) runNode(node rayNode) (ok bool) {
+	switch node := node.(type) {
+
+	case *rayCommand:
+		log.Printf("+ %+v", node.line)
+		if node.background {
+			// starting it is all that can fail here
+			c.startJob(node)
+			c.setStatus(true)
+			return true
+		}
+		succeeded := c.runChain(node.chain)
+		c.setStatus(succeeded)
+		if c.stopIfInterrupted() {
+			return false
+		}
+		if !succeeded {
+			log.Println("Ray failed at", node.line)
+			if c.errexit {
+				c.writeErr("ray", fmt.Sprintf("Stopping because of set -e, failed at %q", node.line))
+				return false
+			}
+		}
+		return true
+
+	case *rayIf:
+		matched := c.checkCondition(node.cond)
+		if c.stopIfInterrupted() {
+			return false
+		}
+		if matched {
+			return c.runNodes(node.then)
+		}
+		return c.runNodes(node.orElse)
+
+	case *rayWhile:
+		for {
+			matched := c.checkCondition(node.cond)
+			if c.stopIfInterrupted() {
+				return false
+			}
+			if !matched {
+				return true
+			}
+			if !c.runNodes(node.body) {
+				return false
+			}
+		}
+
+	case *rayFor:
+		folderPath := path.Join(c.cwd.value, node.folder.expand(c.getVar))
+		folder, ok := c.ctx.GetFolder(folderPath)
+		if !ok {
+			c.writeErr("for", fmt.Sprintf("Couldn't find folder %s", folderPath))
+			c.setStatus(false)
+			return !c.errexit
+		}
+
+		for _, name := range folder.Children() {
+			if c.stopIfInterrupted() {
+				return false
+			}
+			c.environ.Put(node.varName, inmem.NewString(node.varName, name))
+			if !c.runNodes(node.body) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		log.Println("Ray doesn't know how to run", node)
+		return false
+	}
+}
+
+// Conditions set $? like any command, but never trip `set -e`
+func (c *rayCtx) checkCondition(cond rayCondition) bool {
+	log.Printf("+ %+v", cond.cmd.line)
+	succeeded := c.runChain(cond.cmd.chain)
+	c.setStatus(succeeded)
+	return succeeded != cond.negate
+}
+
+// Runs each pipeline in turn, skipping ones after a && that
+// follows a failure or after a || that follows a success
+func (c *rayCtx) runChain(chain *rayChain) (ok bool) {
+	ok = c.runPipeline(chain.pipes[0])
+	for i, join := range chain.joins {
+		if c.interrupted() {
+			return false
+		}
+		if (join == "&&") != ok {
+			continue
+		}
+		ok = c.runPipeline(chain.pipes[i+1])
+	}
+	return
+}
+
+func (c *rayCtx) setStatus(ok bool) {
+	if ok {
+		c.status = 0
+	} else {
+		c.status = 1
+	}
+}
+
+// Evaluates a test expression without writing anything,
+// since a false result isn't an error
+func (c *rayCtx) evalTest(args []string) (result bool, err error) {
+	switch {
+
+	case len(args) == 2 && args[0] == "-e":
+		_, result = c.ctx.Get(path.Join(c.cwd.value, args[1]))
+	case len(args) == 2 && args[0] == "-d":
+		_, result = c.ctx.GetFolder(path.Join(c.cwd.value, args[1]))
+	case len(args) == 2 && args[0] == "-s":
+		if entry, ok := c.ctx.Get(path.Join(c.cwd.value, args[1])); ok {
+			_, result = entry.(base.String)
+		}
+
+	case len(args) == 2 && args[0] == "-z":
+		result = args[1] == ""
+	case len(args) == 2 && args[0] == "-n":
+		result = args[1] != ""
+
+	case len(args) == 3 && args[1] == "=":
+		result = args[0] == args[2]
+	case len(args) == 3 && args[1] == "!=":
+		result = args[0] != args[2]
+
+	case len(args) == 1:
+		result = args[0] != ""
+
+	default:
+		err = fmt.Errorf("can't make sense of %q", strings.Join(args, " "))
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package entries
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// Scripts kept as String entries in the namespace
+
+// Marks a String as a script that can be run by name
+const rayScriptHeader = "#!ray"
+
+// Loads and parses a script, reporting problems as cmd
+func (c *rayCtx) loadScript(cmd, target string) (nodes []rayNode, ok bool) {
+	entry, found := c.ctx.Get(target)
+	if !found {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", target))
+		return nil, false
+	}
+	script, isString := entry.(base.String)
+	if !isString {
+		c.writeErr(cmd, fmt.Sprintf("%s isn't a String", target))
+		return nil, false
+	}
+
+	nodes, err := parseRayScript(script.Get())
+	if err != nil {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't parse %s: %s", target, err))
+		return nil, false
+	}
+	return nodes, true
+}
+
+// Runs nodes as if they were one command, which worked out
+// if nothing stopped them and the last status was zero
+func (c *rayCtx) runAsCommand(nodes []rayNode) bool {
+	return c.runNodes(nodes) && c.status == 0
+}
+
+// source PATH [ARGS...]
+// Runs a script right in this session, so it can change
+// the working directory and variables
+func (c *rayCtx) cmdSource(cmd string, args []string, stdio *rayStdio) bool {
+	if len(args) == 0 {
+		c.writeErr(cmd, "Usage: source PATH [ARGS...]")
+		return false
+	}
+
+	target := c.resolvePath(args[0])
+	nodes, ok := c.loadScript(cmd, target)
+	if !ok {
+		return false
+	}
+
+	prevArgs, prevOutput := c.args, c.output
+	c.args = append([]string{target}, args[1:]...)
+	c.output = stdio.scriptOutput()
+	defer func() { c.args, c.output = prevArgs, prevOutput }()
+	return c.runAsCommand(nodes)
+}
+
+// Finds a #!ray String to run for a name that isn't a builtin
+// Names with a / are paths, others are looked up in each folder
+// listed in $PATH, separated by colons
+func (c *rayCtx) findScript(name string) (target string, ok bool) {
+	var candidates []string
+	if strings.Contains(name, "/") {
+		candidates = []string{c.resolvePath(name)}
+	} else {
+		for _, dir := range strings.Split(c.getVar("PATH"), ":") {
+			if dir != "" {
+				candidates = append(candidates, path.Join(c.resolvePath(dir), name))
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		entry, found := c.ctx.Get(candidate)
+		if !found {
+			continue
+		}
+		if str, isString := entry.(base.String); isString && strings.HasPrefix(str.Get(), rayScriptHeader) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Runs a script like a command, on a copy of the session
+// Changes to the working directory or variables stay inside the script,
+// while Ctrl-C, jobs and piped or redirected output carry through
+func (c *rayCtx) runScriptCommand(cmd, target string, args []string, stdio *rayStdio) bool {
+	nodes, ok := c.loadScript(cmd, target)
+	if !ok {
+		return false
+	}
+
+	environ := inmem.NewFolder("environ")
+	for _, name := range c.environ.Children() {
+		if entry, ok := c.environ.Fetch(name); ok {
+			if str, ok := entry.(base.String); ok {
+				environ.Put(name, inmem.NewString(name, str.Get()))
+			}
+		}
+	}
+
+	child := &rayCtx{
+		ctx:        c.ctx,
+		output:     stdio.scriptOutput(),
+		environ:    environ,
+		cwd:        c.cwd,
+		status:     c.status,
+		errexit:    c.errexit,
+		interrupts: c.interrupts,
+		jobs:       c.jobs,
+		job:        c.job,
+		cancel:     c.cancel,
+		channels:   c.channels,
+		args:       append([]string{target}, args...),
+	}
+	return child.runAsCommand(nodes)
+}
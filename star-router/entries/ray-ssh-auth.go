@@ -0,0 +1,86 @@
+package entries
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/extras"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// Where users are found when the input doesn't say
+// Each user is a folder holding authorized-keys, in the same format
+// as ~/.ssh/authorized_keys, and optionally a bcrypt password-hash
+// httpd won't serve either of those, but export has no such list
+const raySshUsersPath = "/boot/cfg/users"
+
+var errRaySshDenied = errors.New("access denied")
+
+// Finds a user's folder, refusing names that would reach elsewhere
+func (e *raySsh) userFolder(user string) (base.Folder, bool) {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, "/\n") {
+		return nil, false
+	}
+	return e.ctx.GetFolder(path.Join(e.usersPath, user))
+}
+
+func (e *raySsh) checkPublicKey(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	folder, ok := e.userFolder(c.User())
+	if !ok {
+		log.Println("ray-ssh: Unknown user", c.User(), "from", c.RemoteAddr())
+		return nil, errRaySshDenied
+	}
+	authorized, ok := extras.GetChildString(folder, "authorized-keys")
+	if !ok {
+		return nil, errRaySshDenied
+	}
+
+	wanted := key.Marshal()
+	rest := []byte(authorized)
+	for len(rest) > 0 {
+		known, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			// nothing else parses, which includes blank lines at the end
+			break
+		}
+		if bytes.Equal(known.Marshal(), wanted) {
+			log.Println("ray-ssh: Accepted key", fingerprint, "for", c.User(), "from", c.RemoteAddr())
+			return &ssh.Permissions{
+				Extensions: map[string]string{"pubkey-fp": fingerprint},
+			}, nil
+		}
+		rest = next
+	}
+
+	log.Println("ray-ssh: Rejected key", fingerprint, "for", c.User(), "from", c.RemoteAddr())
+	return nil, errRaySshDenied
+}
+
+// Passwords only work for users with a password-hash,
+// which has to be made with bcrypt
+func (e *raySsh) checkPassword(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+	folder, ok := e.userFolder(c.User())
+	if !ok {
+		log.Println("ray-ssh: Unknown user", c.User(), "from", c.RemoteAddr())
+		return nil, errRaySshDenied
+	}
+	hash, ok := extras.GetChildString(folder, "password-hash")
+	if !ok || hash == "" {
+		log.Println("ray-ssh: No password-hash for", c.User(), "from", c.RemoteAddr())
+		return nil, errRaySshDenied
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), pass); err != nil {
+		log.Println("ray-ssh: Wrong password for", c.User(), "from", c.RemoteAddr())
+		return nil, errRaySshDenied
+	}
+	log.Println("ray-ssh: Accepted password for", c.User(), "from", c.RemoteAddr())
+	return nil, nil
+}
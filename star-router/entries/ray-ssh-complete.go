@@ -0,0 +1,148 @@
+package entries
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stardustapp/core/base"
+)
+
+// How long completion waits on a listing before giving up
+// Drivers like aws-ns and consul go over the network for Children()
+const raySshCompleteTimeout = 2 * time.Second
+
+// Completing more names than this would mean fetching them all
+// just to tell folders apart, so larger folders get no trailing /
+const raySshCompleteFetchLimit = 50
+
+// Block keywords, which can start a line like a command
+var rayKeywords = []string{"if", "while", "for", "else", "end"}
+
+// Tab completion for a session's terminal
+// Command names are completed at the start of a command,
+// and namespace paths anywhere else
+type raySshCompleter struct {
+	ctx base.Context
+	cwd func() string
+}
+
+// Fits terminal.AutoCompleteCallback
+// Positions are byte offsets into the line
+func (c *raySshCompleter) complete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		return
+	}
+
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " \t|&><{") + 1
+	word := head[start:]
+
+	var candidates []string
+	if rayCommandPosition(head[:start]) && !strings.Contains(word, "/") {
+		candidates = append(matchingNames(rayKeywords, word), matchingNames(rayCommandNames, word)...)
+	} else {
+		candidates = c.completePath(word)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	if len(completion) <= len(word) {
+		return
+	}
+
+	newLine = head[:start] + completion + line[pos:]
+	return newLine, start + len(completion), true
+}
+
+// Whether the next word would be a command name, meaning it follows
+// nothing but an operator, or the start of a condition like `if !`
+func rayCommandPosition(before string) bool {
+	before = before[strings.LastIndexAny(before, "|&")+1:]
+	fields := strings.Fields(before)
+	if len(fields) == 0 {
+		return true
+	}
+	if fields[0] != "if" && fields[0] != "while" && fields[0] != "!" {
+		return false
+	}
+	for _, field := range fields[1:] {
+		if field != "!" {
+			return false
+		}
+	}
+	return true
+}
+
+// Lists paths starting with the word, keeping the word's own spelling
+// Folders get a trailing / so completion can carry on into them
+func (c *raySshCompleter) completePath(word string) []string {
+	dir, prefix := "", word
+	if idx := strings.LastIndex(word, "/"); idx >= 0 {
+		dir, prefix = word[:idx+1], word[idx+1:]
+	}
+
+	folderPath := dir
+	if !strings.HasPrefix(dir, "/") {
+		folderPath = path.Join(c.cwd(), dir)
+	}
+
+	// the listing can hang, so it's left behind if it takes too long
+	results := make(chan []string, 1)
+	go func() {
+		folder, ok := c.ctx.GetFolder(folderPath)
+		if !ok {
+			results <- nil
+			return
+		}
+
+		names := matchingNames(folder.Children(), prefix)
+		for i, name := range names {
+			if len(names) <= raySshCompleteFetchLimit {
+				if child, ok := folder.Fetch(name); ok {
+					if _, isFolder := child.(base.Folder); isFolder {
+						name += "/"
+					}
+				}
+			}
+			names[i] = dir + name
+		}
+		results <- names
+	}()
+
+	select {
+	case names := <-results:
+		return names
+	case <-time.After(raySshCompleteTimeout):
+		return nil
+	}
+}
+
+// The names that start with prefix, sorted
+func matchingNames(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// The longest start shared by every string
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
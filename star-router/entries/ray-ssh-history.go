@@ -0,0 +1,207 @@
+package entries
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+)
+
+// How many lines of history are kept for each user
+const raySshHistoryLimit = 500
+
+// The terminal keeps its own history and won't take any from outside,
+// so the arrow keys are swapped for these private-use runes before it
+// sees them, and come back through AutoCompleteCallback instead
+const (
+	raySshKeyUp   = '\uf700'
+	raySshKeyDown = '\uf701'
+)
+
+// A user's command history, read when a session starts
+// and merged back after every line, so that sessions running
+// side by side don't write over each other's lines
+// Stored as one String of lines so any namespace can hold it
+// Only touched from the session's terminal goroutine
+type raySshHistory struct {
+	ctx  base.Context
+	path string // empty when history isn't kept
+
+	lines []string
+	index int    // line being shown, or len(lines) when not browsing
+	draft string // what was typed before browsing started
+}
+
+func newRaySshHistory(ctx base.Context, historyPath string) *raySshHistory {
+	h := &raySshHistory{
+		ctx:  ctx,
+		path: historyPath,
+	}
+	if historyPath != "" {
+		// the first user to connect makes the folder
+		dir := path.Dir(historyPath)
+		if _, ok := ctx.Get(dir); !ok {
+			ctx.Put(dir, inmem.NewFolder(path.Base(dir)))
+		}
+		h.lines = readRaySshHistory(ctx, historyPath)
+	}
+	h.index = len(h.lines)
+	return h
+}
+
+// The lines stored at a history path, oldest first
+func readRaySshHistory(ctx base.Context, historyPath string) []string {
+	if entry, ok := ctx.Get(historyPath); ok {
+		if str, ok := entry.(base.String); ok && str.Get() != "" {
+			return strings.Split(str.Get(), "\n")
+		}
+	}
+	return nil
+}
+
+// Records a line that was entered and writes the history back
+// The stored history is reread first, picking up lines that other
+// sessions added since, and the new line goes on the end of that
+// Repeats of the last line are only kept once
+func (h *raySshHistory) add(line string) {
+	h.index = len(h.lines)
+	if strings.TrimSpace(line) == "" || strings.Contains(line, "\n") {
+		return
+	}
+
+	if h.path != "" {
+		h.lines = readRaySshHistory(h.ctx, h.path)
+	}
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		h.index = len(h.lines)
+		return
+	}
+
+	h.lines = append(h.lines, line)
+	if len(h.lines) > raySshHistoryLimit {
+		h.lines = h.lines[len(h.lines)-raySshHistoryLimit:]
+	}
+	h.index = len(h.lines)
+
+	if h.path != "" {
+		value := inmem.NewString(path.Base(h.path), strings.Join(h.lines, "\n"))
+		h.ctx.Put(h.path, value)
+	}
+}
+
+// Steps through history for the arrow keys
+// Fits terminal.AutoCompleteCallback
+func (h *raySshHistory) browse(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	switch key {
+	case raySshKeyUp:
+		if h.index == 0 {
+			return line, pos, true
+		}
+		if h.index == len(h.lines) {
+			h.draft = line
+		}
+		h.index--
+		newLine = h.lines[h.index]
+
+	case raySshKeyDown:
+		if h.index == len(h.lines) {
+			return line, pos, true
+		}
+		h.index++
+		if h.index == len(h.lines) {
+			newLine = h.draft
+		} else {
+			newLine = h.lines[h.index]
+		}
+
+	default:
+		return
+	}
+	return newLine, len(newLine), true
+}
+
+// Passes keystrokes through to the terminal, except for Ctrl-C
+// which calls onInterrupt instead, and the arrow keys which
+// become raySshKeyUp and raySshKeyDown
+type raySshKeyReader struct {
+	r           io.Reader
+	onInterrupt func()
+}
+
+func (r *raySshKeyReader) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil {
+		var read int
+		read, err = r.r.Read(p)
+		in := append([]byte(nil), p[:read]...)
+
+		for i := 0; i < len(in); i++ {
+			if in[i] == 0x03 {
+				r.onInterrupt()
+				continue
+			}
+			if key, ok := raySshArrowAt(in, i); ok {
+				// ESC [ A is as long as the rune it becomes
+				n += utf8.EncodeRune(p[n:], key)
+				i += 2
+				continue
+			}
+			p[n] = in[i]
+			n++
+		}
+	}
+	return
+}
+
+// Spots an up or down arrow, in either cursor key mode
+// Sequences split across reads go through untouched
+func raySshArrowAt(in []byte, i int) (key rune, ok bool) {
+	if i+2 >= len(in) || in[i] != 0x1b || (in[i+1] != '[' && in[i+1] != 'O') {
+		return
+	}
+	switch in[i+2] {
+	case 'A':
+		return raySshKeyUp, true
+	case 'B':
+		return raySshKeyDown, true
+	}
+	return
+}
+
+// history [COUNT]
+// Lists the lines kept at $HISTFILE, which ray-ssh sets
+func (c *rayCtx) cmdHistory(cmd string, args []string, stdio *rayStdio) bool {
+	histPath := c.getVar("HISTFILE")
+	if histPath == "" {
+		c.writeErr(cmd, "No history is kept for this session")
+		return false
+	}
+
+	count := -1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			c.writeErr(cmd, fmt.Sprintf("Bad count %q", args[0]))
+			return false
+		}
+		count = n
+	} else if len(args) > 1 {
+		c.writeErr(cmd, "Usage: history [COUNT]")
+		return false
+	}
+
+	lines := readRaySshHistory(c.ctx, histPath)
+
+	first := 0
+	if count >= 0 && len(lines) > count {
+		first = len(lines) - count
+	}
+	for i := first; i < len(lines); i++ {
+		stdio.writeOut(cmd, fmt.Sprintf("%5d  %s", i+1, lines[i]))
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package entries
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/inmem"
+	"golang.org/x/crypto/ssh"
+)
+
+// Reads the host key from the namespace, making one the first time
+// Keys are stored as PKCS8 PEM Strings, and new ones are ed25519
+// Without a host-key-path, or somewhere writable at it,
+// the key only lasts until the next start
+// httpd hides entries named host-key, but export hands out everything under
+// its input-path, key included, so only let trusted peers import it
+func (e *raySsh) loadHostKey() (ssh.Signer, error) {
+	if e.hostKeyPath != "" {
+		if entry, ok := e.ctx.Get(e.hostKeyPath); ok {
+			str, ok := entry.(base.String)
+			if !ok {
+				return nil, fmt.Errorf("host key at %s isn't a String", e.hostKeyPath)
+			}
+			signer, err := ssh.ParsePrivateKey([]byte(str.Get()))
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse host key at %s: %s", e.hostKeyPath, err)
+			}
+			return signer, nil
+		}
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate a host key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't use the new host key: %s", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	if e.hostKeyPath == "" {
+		log.Println("ray-ssh: No host-key-path given, using a throwaway host key", fingerprint)
+		return signer, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode the new host key: %s", err)
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	dir := path.Dir(e.hostKeyPath)
+	if _, ok := e.ctx.Get(dir); !ok {
+		e.ctx.Put(dir, inmem.NewFolder(path.Base(dir)))
+	}
+	if !e.ctx.Put(e.hostKeyPath, inmem.NewString(path.Base(e.hostKeyPath), string(encoded))) {
+		// a read-only namespace shouldn't keep the server from starting
+		log.Println("ray-ssh: Couldn't store host key", fingerprint, "at", e.hostKeyPath, "- it will only last until the next start")
+		return signer, nil
+	}
+
+	log.Println("ray-ssh: Generated host key", fingerprint, "at", e.hostKeyPath)
+	return signer, nil
+}
@@ -4,12 +4,17 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/danopia/stardust/star-router/render"
 	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/extras"
 	"github.com/stardustapp/core/inmem"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
@@ -19,73 +24,165 @@ import (
 func getRaySshDriver() base.Folder {
 	return inmem.NewFolderOf("ray-ssh",
 		inmem.NewFunction("invoke", raySshFunc),
-		inmem.NewLink("input-shape", "/rom/shapes/function"),
+		inmem.NewLink("input-shape", "/rom/shapes/ray-ssh-opts"),
 	).Freeze()
 }
 
+// Where the shell is found when the input doesn't say
+const raySshShellPath = "/rom/bin/ray"
+
+// Where to listen when the input doesn't say
+const raySshListenAddress = "0.0.0.0:2022"
+
+// How long a client gets to finish logging in
+const raySshHandshakeTimeout = 30 * time.Second
+
 // Function that creates a new ray shell when invoked
+// Takes either the ray function itself, or a folder of options
 func raySshFunc(ctx base.Context, input base.Entry) (output base.Entry) {
+	opts, ok := input.(base.Folder)
+	if !ok {
+		return inmem.NewString("error", "ray-ssh needs a folder as input")
+	}
+
 	service := &raySsh{
 		ctx:       ctx,
-		rayFunc:   input.(base.Folder), // function shape
+		rayFunc:   opts, // function shape
 		tmpFolder: inmem.NewFolder("ray-ssh"),
+		conns:     make(map[net.Conn]bool),
+		exited:    make(chan error, 1),
+
+		usersPath:     raySshUsersPath,
+		listenAddress: raySshListenAddress,
 	}
 
-	service.configure()
-	return service.tmpFolder
+	if _, isFunc := opts.Fetch("invoke"); !isFunc {
+		shellPath, ok := extras.GetChildString(opts, "shell-path")
+		if !ok {
+			shellPath = raySshShellPath
+		}
+		service.rayFunc, ok = ctx.GetFolder(shellPath)
+		if !ok {
+			return inmem.NewString("error", fmt.Sprintf("ray-ssh couldn't find a shell at %s", shellPath))
+		}
+		service.historyPath, _ = extras.GetChildString(opts, "history-path")
+		if usersPath, ok := extras.GetChildString(opts, "users-path"); ok {
+			service.usersPath = usersPath
+		}
+		if listenAddress, ok := extras.GetChildString(opts, "listen-address"); ok {
+			service.listenAddress = listenAddress
+		}
+		service.hostKeyPath, _ = extras.GetChildString(opts, "host-key-path")
+		service.banner, _ = extras.GetChildString(opts, "banner")
+	}
+
+	if err := service.configure(); err != nil {
+		log.Println("ray-ssh:", err)
+		return inmem.NewString("error", err.Error())
+	}
+	return service
 }
 
 // Context for a running SSH server
+// Presents the folder of active sessions
 type raySsh struct {
 	ctx       base.Context
 	sshConfig *ssh.ServerConfig
 	listener  net.Listener
 	rayFunc   base.Folder
 	tmpFolder base.Folder
+
+	// folder holding a String of history for each user, if any
+	historyPath string
+	// folder of users who may log in
+	usersPath string
+	// String holding the host key, made on first start if missing
+	hostKeyPath   string
+	listenAddress string
+	banner        string
+
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	stopping bool
+	exited   chan error
 }
 
-func (e *raySsh) configure() {
-	e.sshConfig = &ssh.ServerConfig{
-		PasswordCallback: e.checkPassword,
-		NoClientAuth:     true,
-	}
+var _ base.Folder = (*raySsh)(nil)
+var _ serviceExiter = (*raySsh)(nil)
 
-	// You can generate a keypair with 'ssh-keygen -t rsa'
-	privateBytes, err := ioutil.ReadFile("id_rsa")
-	if err != nil {
-		log.Println("Failed to load private key (./id_rsa)")
-		return
-	}
+func (e *raySsh) Name() string {
+	return e.tmpFolder.Name()
+}
 
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		log.Println("Failed to parse private key")
-		return
+func (e *raySsh) Children() []string {
+	return e.tmpFolder.Children()
+}
+
+func (e *raySsh) Fetch(name string) (entry base.Entry, ok bool) {
+	return e.tmpFolder.Fetch(name)
+}
+
+func (e *raySsh) Put(name string, entry base.Entry) (ok bool) {
+	return e.tmpFolder.Put(name, entry)
+}
+
+// Closes the listener and hangs up on every connected client
+func (e *raySsh) Stop() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.stopping = true
+	if e.listener != nil {
+		e.listener.Close()
+	}
+	for conn := range e.conns {
+		conn.Close()
 	}
+	log.Println("ray-ssh: Stopped listening")
+}
 
-	e.sshConfig.AddHostKey(private)
-	e.start()
+// Reports the listener failing for good, so init can restart us,
+// and is closed once Stop has shut the listener
+func (e *raySsh) Exited() <-chan error {
+	return e.exited
 }
 
-func (e *raySsh) checkPassword(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-	log.Println("Login attempt for", c.User(), "-", string(pass))
-	if c.User() == "star" && string(pass) == "dust" {
-		return nil, nil
+func (e *raySsh) configure() error {
+	e.sshConfig = &ssh.ServerConfig{
+		PublicKeyCallback: e.checkPublicKey,
+		PasswordCallback:  e.checkPassword,
+	}
+
+	if e.banner != "" {
+		banner := e.banner
+		if !strings.HasSuffix(banner, "\n") {
+			banner += "\n"
+		}
+		e.sshConfig.BannerCallback = func(ssh.ConnMetadata) string {
+			return banner
+		}
+	}
+
+	hostKey, err := e.loadHostKey()
+	if err != nil {
+		return err
 	}
-	return nil, nil // fmt.Errorf("password rejected for %q", c.User())
+	e.sshConfig.AddHostKey(hostKey)
+	return e.start()
 }
 
-func (e *raySsh) start() {
+func (e *raySsh) start() error {
 	// Once a ServerConfig has been configured, connections can be
 	// accepted.
-	listener, err := net.Listen("tcp", "0.0.0.0:2022")
+	listener, err := net.Listen("tcp", e.listenAddress)
 	if err != nil {
-		log.Fatalf("failed to listen for connection: %+v", err)
+		return fmt.Errorf("couldn't listen on %s: %s", e.listenAddress, err)
 	}
 	e.listener = listener
 
-	log.Println("Listening for SSH on port 2022")
+	log.Println("ray-ssh: Listening on", listener.Addr())
 	go e.run()
+	return nil
 }
 
 func (e *raySsh) run() {
@@ -94,32 +191,83 @@ func (e *raySsh) run() {
 		// themselves be Accepted.
 		tcpConn, err := e.listener.Accept()
 		if err != nil {
+			e.lock.Lock()
+			stopping := e.stopping
+			e.lock.Unlock()
+			if stopping {
+				// tells init this was on purpose
+				close(e.exited)
+				return
+			}
+
+			// only a temporary error is worth another try
+			if netErr, ok := err.(net.Error); !ok || !netErr.Temporary() {
+				log.Println("ray-ssh: Listener failed:", err)
+				e.exited <- err
+				return
+			}
 			log.Println("failed to accept incoming connection", err)
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
+		e.trackConn(tcpConn, true)
+		go e.handleConn(tcpConn)
+	}
+}
 
-		// Before use, a handshake must be performed on the incoming net.Conn.
-		sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, e.sshConfig)
-		if err != nil {
-			log.Println("Failed to perform SSH handshake", err)
-			continue
-		}
+// Performs the handshake for a new connection and serves it
+// Logging in looks things up in the namespace, which can be slow,
+// so each connection gets its own goroutine and a deadline
+func (e *raySsh) handleConn(tcpConn net.Conn) {
+	tcpConn.SetDeadline(time.Now().Add(raySshHandshakeTimeout))
 
-		log.Printf("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
+	// Before use, a handshake must be performed on the incoming net.Conn.
+	sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, e.sshConfig)
+	if err != nil {
+		log.Println("Failed to perform SSH handshake", err)
+		e.trackConn(tcpConn, false)
+		tcpConn.Close()
+		return
+	}
+	tcpConn.SetDeadline(time.Time{})
+
+	log.Printf("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
+	go func() {
+		sshConn.Wait()
+		e.trackConn(tcpConn, false)
+	}()
 
-		go ssh.DiscardRequests(reqs)
-		go e.handleChannels(chans, fmt.Sprintf("%s", sshConn.RemoteAddr()))
+	go ssh.DiscardRequests(reqs)
+	e.handleChannels(chans, fmt.Sprintf("%s", sshConn.RemoteAddr()), sshConn.User())
+}
+
+// Remembers open connections so Stop can hang up on them
+func (e *raySsh) trackConn(conn net.Conn, open bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if open {
+		e.conns[conn] = true
+	} else {
+		delete(e.conns, conn)
 	}
 }
 
-func (e *raySsh) handleChannels(chans <-chan ssh.NewChannel, addr string) {
+func (e *raySsh) handleChannels(chans <-chan ssh.NewChannel, addr, user string) {
 	// Service the incoming Channel channel in go routine
 	for newChannel := range chans {
-		go e.handleChannel(newChannel, addr)
+		go e.handleChannel(newChannel, addr, user)
+	}
+}
+
+// Where a user's history lives, or empty if it isn't kept
+func (e *raySsh) userHistoryPath(user string) string {
+	if e.historyPath == "" || user == "" || strings.ContainsAny(user, "/\n") {
+		return ""
 	}
+	return path.Join(e.historyPath, user)
 }
 
-func (e *raySsh) handleChannel(ch ssh.NewChannel, addr string) {
+func (e *raySsh) handleChannel(ch ssh.NewChannel, addr, user string) {
 	// Since we're handling a shell, we expect a
 	// channel type of "session". The also describes
 	// "x11", "direct-tcpip" and "forwarded-tcpip"
@@ -161,6 +309,12 @@ func (e *raySsh) handleChannel(ch ssh.NewChannel, addr string) {
 	outputLog := outputEnt.(base.Log)
 	output := outputLog.Subscribe(nil)
 
+	interruptEnt, ok := ray.Fetch("interrupt")
+	if !ok {
+		panic("wat3")
+	}
+	interrupt := interruptEnt.(base.Function)
+
 	cwdEnt, ok := ray.Fetch("cwd")
 	if !ok {
 		panic("wat2a")
@@ -170,7 +324,39 @@ func (e *raySsh) handleChannel(ch ssh.NewChannel, addr string) {
 		panic("wat2b")
 	}
 
-	term := terminal.NewTerminal(connection, "> ")
+	// let the history command find what we keep
+	history := newRaySshHistory(e.ctx, e.userHistoryPath(user))
+	if history.path != "" {
+		if environEnt, ok := ray.Fetch("environ"); ok {
+			if environ, ok := environEnt.(base.Folder); ok {
+				environ.Put("HISTFILE", inmem.NewString("HISTFILE", history.path))
+			}
+		}
+	}
+
+	// the terminal ignores Ctrl-C, so it's picked out beforehand
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{
+		&raySshKeyReader{connection, func() {
+			interrupt.Invoke(e.ctx, nil)
+		}},
+		connection,
+	}, "> ")
+
+	completer := &raySshCompleter{
+		ctx: e.ctx,
+		cwd: func() string {
+			return cwdFunc.Invoke(e.ctx, nil).(base.String).Get()
+		},
+	}
+	term.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key == '\t' {
+			return completer.complete(line, pos, key)
+		}
+		return history.browse(line, pos, key)
+	}
 
 	go func() {
 		defer connection.Close()
@@ -185,11 +371,26 @@ func (e *raySsh) handleChannel(ch ssh.NewChannel, addr string) {
 			}
 			line, ok := entry.(base.String)
 			if !ok {
-				log.Println("Ray failed to get string from output", entry)
-				return
+				// anything fancier than a line gets pretty-printed
+				for _, text := range render.Text(entry, render.DefaultOptions) {
+					term.Write([]byte(text + "\n"))
+				}
+				continue
 			}
 
-			term.Write([]byte(line.Get() + "\n"))
+			text := line.Get()
+			if _, err := strconv.Atoi(line.Name()); err == nil {
+				// background jobs label their output with the job id
+				text = fmt.Sprintf("[%s] %s", line.Name(), text)
+			}
+
+			if line.Name() == "error" {
+				term.Write(term.Escape.Red)
+				term.Write([]byte(text + "\n"))
+				term.Write(term.Escape.Reset)
+			} else {
+				term.Write([]byte(text + "\n"))
+			}
 		}
 	}()
 
@@ -233,16 +434,19 @@ func (e *raySsh) handleChannel(ch ssh.NewChannel, addr string) {
 		term.SetPrompt(fmt.Sprintf("%s $ ", cwdFunc.Invoke(e.ctx, nil).(base.String).Get()))
 
 		line, err := term.ReadLine()
-		if err == io.EOF {
+		if err != nil {
+			if err != io.EOF {
+				// a broken terminal would fail every read from here on
+				log.Println("handleChannel readLine err:", err)
+			}
+			// anything still following would otherwise run forever
+			interrupt.Invoke(e.ctx, nil)
 			commands.Close()
 			log.Println("Client disconnected", addr)
 			return
 		}
-		if err != nil {
-			log.Println("handleChannel readLine err:", err)
-			continue
-		}
 		if len(line) > 0 {
+			history.add(line)
 			commands.Push(inmem.NewString("ssh-command", line))
 			time.Sleep(100 * time.Millisecond)
 		}
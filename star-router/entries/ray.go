@@ -1,14 +1,14 @@
 package entries
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/mattn/go-shellwords"
+	"github.com/danopia/stardust/star-router/render"
 	"github.com/stardustapp/core/base"
 	"github.com/stardustapp/core/inmem"
 )
@@ -43,61 +43,142 @@ type rayCtx struct {
 	ctx      base.Context
 	commands base.Channel
 	output   base.Log
-	//result   base.Channel
-	environ base.Folder
-	cwd     cwdProvider
+	result   base.Channel
+	environ  base.Folder
+	cwd      cwdProvider
+
+	status  int  // of the last command, as $?
+	errexit bool // stop at the first failure, from `set -e`
+
+	interrupts chan struct{} // Ctrl-C, pending until the line is done
+
+	jobs     *rayJobs           // shared by the session and its jobs
+	job      *rayJob            // set when this is a job's copy of the ray
+	cancel   <-chan struct{}    // closed when this job is killed
+	channels *rayChannelReaders // what watch is reading, also shared
+
+	args []string // $0 and up, while running a script
 }
 
+// Where scripts are looked up by name until $PATH is changed
+const rayDefaultPath = "/boot/bin:/rom/bin"
+
 func newRayCtx(cctx base.Context) *rayCtx {
 	log.Println("Starting new Ray")
 	ctx := &rayCtx{
 		ctx:      cctx,
 		commands: inmem.NewSyncChannel("commands"),
 		output:   inmem.NewLog("output"),
-		//result:   inmem.NewBufferedChannel("result", 1),
-		environ: inmem.NewFolder("environ"),
+		result:   inmem.NewBufferedChannel("result", 1),
+		environ:  inmem.NewFolder("environ"),
+
+		interrupts: make(chan struct{}, 1),
+		jobs:       newRayJobs(),
+		channels:   newRayChannelReaders(),
 	}
 	ctx.cwd.value = "/"
+	ctx.environ.Put("PATH", inmem.NewString("PATH", rayDefaultPath))
 	return ctx
 }
 
+// Runs commands until they run out, then offers up
+// the outcome on the result channel
+// Lines inside a block are held until its end arrives
+// Failures only end the session after `set -e`
 func (c *rayCtx) pumpCommands() {
-	defer c.output.Close()
+	result := inmem.NewString("result", "ok")
+	defer func() { c.finish(result) }()
+
+	var parser rayParser
 	for {
 		entry, ok := c.commands.Next()
 		if !ok {
+			if parser.pending() {
+				result = inmem.NewString("error", "commands ended inside a block")
+			}
 			return
 		}
 		line, ok := entry.(base.String)
 		if !ok {
 			log.Println("Ray failed to get string from", entry)
+			result = inmem.NewString("error", "got a non-String command")
 			return
 		}
 
-		log.Printf("+ %+v", line.Get())
-
-		parts, err := shellwords.Parse(line.Get())
+		node, err := parser.feed(line.Get())
 		if err != nil {
 			log.Println("Ray failed to parse:", err)
-			return
+			c.writeErr("ray", fmt.Sprintf("Couldn't parse: %s", err))
+			parser.reset()
+			c.setStatus(false)
+			if c.errexit {
+				result = inmem.NewString("error", fmt.Sprintf("couldn't parse %q: %s", line.Get(), err))
+				return
+			}
+			continue
+		}
+		if node == nil {
+			continue
 		}
 
-		ok = c.evalCommand(parts[0], parts[1:])
-		if !ok {
-			log.Println("Ray failed at", line.Get())
+		c.clearInterrupt()
+		if !c.runNode(node) {
+			if c.status == rayInterruptedStatus {
+				// Ctrl-C only stops the line, not the session
+				c.clearInterrupt()
+				continue
+			}
+			result = inmem.NewString("error", fmt.Sprintf("failed at %q", line.Get()))
 			return
 		}
+	}
+}
 
+// Parses and runs a whole script, then offers up the outcome
+func (c *rayCtx) runScript(script string) {
+	result := inmem.NewString("result", "ok")
+	defer func() { c.finish(result) }()
+
+	nodes, err := parseRayScript(script)
+	if err != nil {
+		log.Println("Ray failed to parse:", err)
+		c.writeErr("ray", fmt.Sprintf("Couldn't parse: %s", err))
+		result = inmem.NewString("error", fmt.Sprintf("couldn't parse script: %s", err))
+		return
+	}
+
+	// like a shell, the last command decides how the script went
+	if !c.runNodes(nodes) {
+		if c.status == rayInterruptedStatus {
+			result = inmem.NewString("error", "script was interrupted")
+		} else {
+			result = inmem.NewString("error", "script stopped after a failure")
+		}
+	} else if c.status != 0 {
+		result = inmem.NewString("error", fmt.Sprintf("script ended with status %d", c.status))
 	}
 }
 
+func (c *rayCtx) finish(result base.String) {
+	c.jobs.killAll()
+	c.channels.releaseAll()
+	c.output.Close()
+	c.result.Push(result)
+	c.result.Close()
+}
+
 func (c *rayCtx) getBundle() base.Folder {
 	return inmem.NewFolderOf("ray-invocation",
 		c.commands,
 		c.output,
-		//c.result,
+		c.result,
 		c.environ,
 		&c.cwd,
+		c.jobs,
+		inmem.NewFunction("interrupt", func(ctx base.Context, input base.Entry) base.Entry {
+			c.interrupt()
+			return nil
+		}),
 	).Freeze()
 }
 
@@ -105,29 +186,127 @@ func (c *rayCtx) writeOut(label, line string) {
 	c.output.Append(inmem.NewString(label, line))
 }
 
-func (c *rayCtx) evalCommand(cmd string, args []string) (ok bool) {
+// Errors always go to the log, even when output is piped elsewhere,
+// and are labelled "error" so clients can tell them apart
+func (c *rayCtx) writeErr(cmd, line string) {
+	c.output.Append(inmem.NewString("error", fmt.Sprintf("%s: %s", cmd, line)))
+}
+
+// Reads a variable from the environ folder, empty if unset
+func (c *rayCtx) getVar(name string) string {
+	if name == "?" {
+		return strconv.Itoa(c.status)
+	}
+	if idx, err := strconv.Atoi(name); err == nil && idx >= 0 {
+		if idx < len(c.args) {
+			return c.args[idx]
+		}
+		return ""
+	}
+	if entry, ok := c.environ.Fetch(name); ok {
+		if str, ok := entry.(base.String); ok {
+			return str.Get()
+		}
+	}
+	return ""
+}
+
+// Finds what invoke should call, either a Function itself or a folder
+// holding one as invoke, and where the function's input-shape would be
+func (c *rayCtx) resolveFunction(cmd, arg string) (function base.Function, shapePath string, ok bool) {
+	target := path.Join(c.cwd.value, arg)
+	entry, found := c.ctx.Get(target)
+	if !found {
+		c.writeErr(cmd, fmt.Sprintf("Couldn't find function named %s", arg))
+		return nil, "", false
+	}
+
+	if folder, isFolder := entry.(base.Folder); isFolder {
+		entry, _ = folder.Fetch("invoke")
+		shapePath = path.Join(target, "input-shape")
+	} else if path.Base(target) == "invoke" {
+		shapePath = path.Join(path.Dir(target), "input-shape")
+	}
+
+	function, ok = entry.(base.Function)
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("%s isn't a function", arg))
+	}
+	return
+}
+
+// Refuses input that doesn't fit the function's input-shape
+// Functions without a shape take anything
+func (c *rayCtx) checkInput(cmd, shapePath string, input base.Entry) bool {
+	if shapePath == "" {
+		return true
+	}
+	shape, found := c.ctx.GetShape(shapePath)
+	if !found {
+		return true
+	}
+
+	violations := checkShape(c.ctx, shape, input)
+	for _, v := range violations {
+		c.writeErr(cmd, fmt.Sprintf("input: %s", v))
+	}
+	return len(violations) == 0
+}
+
+func (c *rayCtx) expandWords(words []rayWord) []string {
+	args := make([]string, len(words))
+	for i, word := range words {
+		args[i] = word.expand(c.getVar)
+	}
+	return args
+}
+
+// Every builtin, as listed by help and offered for completion
+var rayCommandNames = []string{"help", "cat", "cd", "echo", "ls", "ll", "invoke", "set", "unset", "env", "test", "cp", "mv", "rm", "mkdir", "write", "ln", "tree", "stat", "find", "grep", "tail", "watch", "push", "jobs", "wait", "kill", "source", "history", ":"}
+
+func (c *rayCtx) evalCommand(cmd string, args []string, stdio *rayStdio) (ok bool) {
+	name := cmd // scripts are found by their exact name
 	cmd = strings.ToLower(cmd)
 	switch cmd {
 
 	case "help":
-		c.writeOut(cmd, "Available commands:")
-		cmdList := []string{"help", "cat", "cd", "echo", "ls", "invoke", ":"}
-		for _, cmd := range cmdList {
-			c.writeOut(cmd, fmt.Sprintf("  - %s", cmd))
+		stdio.writeOut(cmd, "Available commands:")
+		for _, cmd := range rayCommandNames {
+			stdio.writeOut(cmd, fmt.Sprintf("  - %s", cmd))
 			time.Sleep(10 * time.Millisecond)
 		}
-		c.writeOut(cmd, "")
-		c.writeOut(cmd, "The shell will exit on any error.")
+		stdio.writeOut(cmd, "")
+		stdio.writeOut(cmd, "Blocks: if COND / else / end, while COND / end, for NAME in FOLDER / end")
+		stdio.writeOut(cmd, "Chaining: CMD && CMD, CMD || CMD. The last status is in $?")
+		stdio.writeOut(cmd, "Failed commands don't stop the shell unless you `set -e` first.")
+		stdio.writeOut(cmd, "Inline input: invoke PATH {key=value sub={key=value}}")
+		stdio.writeOut(cmd, "tail -f and watch keep going until Ctrl-C.")
+		stdio.writeOut(cmd, "End a line with & to run it in the background, see jobs, wait and kill.")
+		stdio.writeOut(cmd, "Strings starting with #!ray run by name from the folders in $PATH, with arguments in $1 and up.")
 		ok = true
 
 	case ":":
 		ok = true
 
+	case "test":
+		var err error
+		if ok, err = c.evalTest(args); err != nil {
+			c.writeErr(cmd, err.Error())
+		}
+
 	case "cat":
 		ok = true
+		if len(args) == 0 && stdio.input != nil {
+			if str, isStr := stdio.input.(base.String); isStr {
+				stdio.writeOut(cmd, str.Get())
+			} else {
+				stdio.emit(cmd, stdio.input)
+			}
+		}
 		for _, subPath := range args {
 			entry, ok := c.ctx.Get(path.Join(c.cwd.value, subPath))
 			if !ok {
+				c.writeErr(cmd, fmt.Sprintf("Couldn't find %s", subPath))
 				return ok
 			}
 
@@ -135,67 +314,58 @@ func (c *rayCtx) evalCommand(cmd string, args []string) (ok bool) {
 			case base.String:
 				var value string
 				value = entry.Get()
-				c.writeOut(cmd, value)
+				stdio.writeOut(cmd, value)
 
 			default:
-				c.writeOut(cmd, "Name wasn't a type that you can cat")
+				c.writeErr(cmd, "Name wasn't a type that you can cat")
 				return false
 			}
 		}
 
 	case "invoke":
 		if len(args) == 0 {
-			c.writeOut(cmd, "Not enough args for invoke")
+			c.writeErr(cmd, "Not enough args for invoke")
 			return
 		}
 
-		var functionE base.Entry
-		functionE, ok = c.ctx.Get(path.Join(c.cwd.value, args[0]))
-		if !ok {
-			c.writeOut(cmd, fmt.Sprintf("Couldn't find function named %s", args[0]))
-			return ok
+		function, shapePath, found := c.resolveFunction(cmd, args[0])
+		if !found {
+			return false
 		}
-		function := functionE.(base.Function)
 
-		var input base.Entry
-		if len(args) >= 2 && args[1] != "/dev/null" {
+		// input from a pipe unless given a path or a literal
+		input := stdio.input
+		if literal, isLiteral := stdio.literal(1); isLiteral {
+			input = literal
+			if !c.checkInput(cmd, shapePath, literal) {
+				return false
+			}
+		} else if len(args) >= 2 && args[1] != "/dev/null" {
 			input, ok = c.ctx.Get(path.Join(c.cwd.value, args[1]))
 			if !ok {
-				c.writeOut(cmd, fmt.Sprintf("Couldn't find input named %s", args[1]))
+				c.writeErr(cmd, fmt.Sprintf("Couldn't find input named %s", args[1]))
 				return ok
 			}
 		}
 
 		output := function.Invoke(c.ctx, input)
 
+		// drivers report failure with an "error" String
+		if str, isStr := output.(base.String); isStr && str.Name() == "error" {
+			c.writeErr(cmd, str.Get())
+			return false
+		}
+
 		if output != nil {
 			if len(args) >= 3 && args[2] != "/dev/null" {
 				ok = c.ctx.Put(path.Join(c.cwd.value, args[2]), output)
 				if !ok {
-					c.writeOut(cmd, fmt.Sprintf("Couldn't write output to %s", output))
+					c.writeErr(cmd, fmt.Sprintf("Couldn't write output to %s", output))
 					return ok
 				}
-				c.writeOut(cmd, fmt.Sprintf("Wrote result to %s", args[2]))
+				stdio.writeOut(cmd, fmt.Sprintf("Wrote result to %s", args[2]))
 			} else {
-				switch output := output.(type) {
-				case base.String:
-					c.writeOut(cmd, fmt.Sprintf("=> %s", output.Get()))
-				case base.Folder:
-					for _, name := range output.Children() {
-						if child, ok := output.Fetch(name); ok {
-							if str, ok := child.(base.String); ok {
-								c.writeOut(cmd, fmt.Sprintf("%s: %s", name, str.Get()))
-							} else {
-								c.writeOut(cmd, fmt.Sprintf("%s: unknown, lol", name))
-							}
-						} else {
-							c.writeOut(cmd, fmt.Sprintf("%s: missing, lol", name))
-						}
-					}
-				default:
-					// TODO: support more outputs, general printing library
-					c.writeOut(cmd, fmt.Sprintf("Output was not printable"))
-				}
+				stdio.emit(cmd, output)
 			}
 		}
 
@@ -204,47 +374,98 @@ func (c *rayCtx) evalCommand(cmd string, args []string) (ok bool) {
 			_, ok = c.ctx.GetFolder(path.Join(c.cwd.value, args[0]))
 			if ok {
 				c.cwd.value = path.Join(c.cwd.value, args[0])
+			} else {
+				c.writeErr(cmd, fmt.Sprintf("No such folder %s", args[0]))
 			}
 		} else if len(args) == 0 {
 			c.cwd.value = "/"
 			ok = true
+		} else {
+			c.writeErr(cmd, "Too many args")
 		}
 
+	case "cp":
+		ok = c.cmdCopy(cmd, args)
+	case "mv":
+		ok = c.cmdMove(cmd, args)
+	case "rm":
+		ok = c.cmdRemove(cmd, args)
+	case "mkdir":
+		ok = c.cmdMakeFolder(cmd, args)
+	case "write":
+		ok = c.cmdWrite(cmd, args, stdio)
+	case "ln":
+		ok = c.cmdLink(cmd, args)
+
+	case "tree":
+		ok = c.cmdTree(cmd, args, stdio)
+	case "stat":
+		ok = c.cmdStat(cmd, args, stdio)
+	case "find":
+		ok = c.cmdFind(cmd, args, stdio)
+	case "grep":
+		ok = c.cmdGrep(cmd, args, stdio)
+
+	case "tail":
+		ok = c.cmdTail(cmd, args, stdio)
+	case "watch":
+		ok = c.cmdWatch(cmd, args, stdio)
+	case "push":
+		ok = c.cmdPush(cmd, args, stdio)
+
+	case "jobs":
+		ok = c.cmdJobs(cmd, args, stdio)
+	case "wait":
+		ok = c.cmdWait(cmd, args, stdio)
+	case "kill":
+		ok = c.cmdKill(cmd, args, stdio)
+
 	case "echo":
 		text := strings.Join(args, " ")
-		c.writeOut(cmd, text)
+		stdio.writeOut(cmd, text)
+		ok = true
+
+	case "set":
+		if len(args) == 1 && (args[0] == "-e" || args[0] == "+e") {
+			c.errexit = args[0] == "-e"
+			return true
+		}
+		if len(args) == 0 || !isRayName(args[0]) {
+			c.writeErr(cmd, "Usage: set NAME value...")
+			return
+		}
+		value := strings.Join(args[1:], " ")
+		ok = c.environ.Put(args[0], inmem.NewString(args[0], value))
+		if !ok {
+			c.writeErr(cmd, fmt.Sprintf("Couldn't set %s", args[0]))
+		}
+
+	case "unset":
+		ok = true
+		for _, name := range args {
+			c.environ.Put(name, nil)
+		}
+
+	case "env":
+		for _, name := range c.environ.Children() {
+			stdio.writeOut(cmd, fmt.Sprintf("%s=%s", name, c.getVar(name)))
+		}
 		ok = true
 
 	case "ls":
 		var folder base.Folder
-		if len(args) == 1 {
-			if folder, ok = c.ctx.GetFolder(path.Join(c.cwd.value, args[0])); !ok {
-				return
-			}
-		} else if len(args) == 0 {
-			if folder, ok = c.ctx.GetFolder(c.cwd.value); !ok {
-				return
-			}
-		} else {
+		if folder, ok = c.argFolder(cmd, args); !ok {
 			return
 		}
 
 		// TODO: can't this fail?
 		names := folder.Children()
 		text := strings.Join(names, "\t")
-		c.writeOut(cmd, text)
+		stdio.writeOut(cmd, text)
 
 	case "ll":
 		var folder base.Folder
-		if len(args) == 1 {
-			if folder, ok = c.ctx.GetFolder(path.Join(c.cwd.value, args[0])); !ok {
-				return
-			}
-		} else if len(args) == 0 {
-			if folder, ok = c.ctx.GetFolder(c.cwd.value); !ok {
-				return
-			}
-		} else {
+		if folder, ok = c.argFolder(cmd, args); !ok {
 			return
 		}
 
@@ -252,38 +473,44 @@ func (c *rayCtx) evalCommand(cmd string, args []string) (ok bool) {
 		names := folder.Children()
 		for _, name := range names {
 			entry, _ := folder.Fetch(name)
-			var extra string
-
-			switch entry := entry.(type) {
-			case base.String:
-				extra = "string"
-				if txt, err := json.Marshal(entry.Get()); err == nil {
-					extra = fmt.Sprintf("string %v", string(txt))
-				}
+			extra := render.Summary(entry)
 
-			case base.Folder:
-				extra = fmt.Sprintf("folder (%d children)", len(entry.Children()))
-
-			case base.Log:
-				extra = "log"
-			case base.Channel:
-				extra = "queue"
-			case base.Function:
-				extra = "function"
-			case base.List:
-				extra = "list"
-			case base.File:
-				extra = "file"
-			}
-
-			c.writeOut(cmd, fmt.Sprintf("%s\t %s", name, extra))
+			stdio.writeOut(cmd, fmt.Sprintf("%s\t %s", name, extra))
 			time.Sleep(10 * time.Millisecond)
 		}
 		ok = true
 
+	case "source":
+		ok = c.cmdSource(cmd, args, stdio)
+
+	case "history":
+		ok = c.cmdHistory(cmd, args, stdio)
+
 	default:
-		text := fmt.Sprintf("No such command: %v", cmd)
-		c.writeOut(cmd, text)
+		if target, found := c.findScript(name); found {
+			ok = c.runScriptCommand(name, target, args, stdio)
+			break
+		}
+		c.writeErr(cmd, fmt.Sprintf("No such command: %v", cmd))
+	}
+	return
+}
+
+// Finds the folder named by an optional single argument,
+// defaulting to the working directory
+func (c *rayCtx) argFolder(cmd string, args []string) (folder base.Folder, ok bool) {
+	switch len(args) {
+	case 0:
+		folder, ok = c.ctx.GetFolder(c.cwd.value)
+	case 1:
+		folder, ok = c.ctx.GetFolder(path.Join(c.cwd.value, args[0]))
+	default:
+		c.writeErr(cmd, "Too many args")
+		return
+	}
+
+	if !ok {
+		c.writeErr(cmd, fmt.Sprintf("No such folder %s", path.Join(c.cwd.value, strings.Join(args, ""))))
 	}
 	return
 }
@@ -295,20 +522,9 @@ func rayFunc(cctx base.Context, input base.Entry) (output base.Entry) {
 
 	switch input := input.(type) {
 	case base.String:
-
-		go func(ctx *rayCtx) {
-			script := input.Get()
-			lines := strings.Split(script, "\n")
-			for _, raw := range lines {
-				line := strings.Trim(raw, " \t")
-				if len(line) == 0 {
-					continue
-				}
-
-				ctx.commands.Push(inmem.NewString("ray-input", line))
-			}
-			ctx.commands.Close()
-		}(ctx)
+		// scripts don't take commands
+		ctx.commands.Close()
+		go ctx.runScript(input.Get())
 
 	//case base.Channel:
 	//	ctx.commands = input // TODO: do this earlier
@@ -318,10 +534,10 @@ func rayFunc(cctx base.Context, input base.Entry) (output base.Entry) {
 			log.Println("Ray can't deal with input", input)
 			panic("Ray got weird input")
 		}
+		go ctx.pumpCommands()
 	}
 
 	go ctx.writeOutputToStdout() // TODO
-	go ctx.pumpCommands()
 	return ctx.getBundle()
 }
 
@@ -334,8 +550,10 @@ func (c *rayCtx) writeOutputToStdout() {
 		}
 		line, ok := entry.(base.String)
 		if !ok {
-			log.Println("Ray failed to get string from output", entry)
-			return
+			for _, text := range render.Text(entry, render.DefaultOptions) {
+				log.Printf("> %s", text)
+			}
+			continue
 		}
 
 		log.Printf("> %+v", line.Get())
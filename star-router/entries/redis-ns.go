@@ -188,7 +188,7 @@ func (e *redisNsFolder) Put(name string, entry base.Entry) (ok bool) {
 		nid = entry.nid
 
 	case base.Folder:
-		nid = e.ns.newNode(entry.Name(), "Folder")
+		nid = e.ns.newNode(name, "Folder")
 		dest := e.ns.getEntry(nid).(base.Folder)
 
 		// recursively copy entire folder to redis
@@ -201,15 +201,15 @@ func (e *redisNsFolder) Put(name string, entry base.Entry) (ok bool) {
 		}
 
 	case base.String:
-		nid = e.ns.newNode(entry.Name(), "String")
+		nid = e.ns.newNode(name, "String")
 		e.ns.svc.Set(e.ns.prefixFor(nid, "value"), entry.Get(), 0)
 
 	case base.Link:
-		nid = e.ns.newNode(entry.Name(), "Link")
+		nid = e.ns.newNode(name, "Link")
 		e.ns.svc.Set(e.ns.prefixFor(nid, "target"), entry.Target(), 0)
 
 	case base.File:
-		nid = e.ns.newNode(entry.Name(), "File")
+		nid = e.ns.newNode(name, "File")
 
 		size := entry.GetSize()
 		data := entry.Read(0, int(size))
@@ -1,7 +1,7 @@
 package entries
 
 var romData = map[string]string{
-	"init-script":                               "echo \"Starting up...\"\ninvoke /rom/drv/aws/clone /boot/cfg/aws-creds /n/aws\ninvoke /rom/bin/ray-ssh /rom/bin/ray /n/ray-ssh\ninvoke /n/aws/sqs/receive-message /boot/cfg/aws-github-queue /tmp/message\n: cat /tmp/message/body\necho \"Done booting service\"",
+	"init-script":                               "echo \"Starting up...\"\nif test -d /n/aws/sqs && test -d /boot/cfg/aws-github-queue\n  invoke /n/aws/sqs/receive-message /boot/cfg/aws-github-queue /tmp/message\n  cat /tmp/message/body\nend\necho \"Done booting service\"",
 	"services/export/":                          "",
 	"services/export/input-path":                "/",
 	"services/export/mount-path":                "/n/nsexport",
@@ -10,6 +10,7 @@ var romData = map[string]string{
 	"services/httpd/input-path":                 "/",
 	"services/httpd/mount-path":                 "/n/httpd",
 	"services/httpd/path":                       "/rom/bin/httpd",
+	"services/httpd/restart-policy":             "on-failure",
 	"services/kubernetes-apt/":                  "",
 	"services/kubernetes-apt/input/":            "",
 	"services/kubernetes-apt/input/config-path": "",
@@ -21,9 +22,15 @@ var romData = map[string]string{
 	"services/os-fs/mount-path":                 "/n/osfs",
 	"services/os-fs/path":                       "/rom/drv/host-os/fs",
 	"services/ray-ssh/":                         "",
-	"services/ray-ssh/input-path":               "/rom/bin/ray",
+	"services/ray-ssh/input/":                   "",
+	"services/ray-ssh/input/history-path":       "/n/redis-ns/ray-history",
+	"services/ray-ssh/input/host-key-path":      "/n/redis-ns/ray-ssh/host-key",
+	"services/ray-ssh/input/listen-address":     "0.0.0.0:2022",
+	"services/ray-ssh/input/shell-path":         "/rom/bin/ray",
+	"services/ray-ssh/input/users-path":         "/boot/cfg/users",
 	"services/ray-ssh/mount-path":               "/n/ray-ssh",
 	"services/ray-ssh/path":                     "/rom/bin/ray-ssh",
+	"services/ray-ssh/requires":                 "redis-ns",
 	"services/redis-ns/":                        "",
 	"services/redis-ns/input/":                  "",
 	"services/redis-ns/input/address":           "apt.lan:31500",
@@ -0,0 +1,111 @@
+package entries
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/danopia/stardust/star-router/render"
+	"github.com/stardustapp/core/base"
+	"github.com/stardustapp/core/extras"
+	"github.com/stardustapp/core/inmem"
+)
+
+// One way that an entry doesn't fit a shape
+type shapeViolation struct {
+	Path   string // location within the checked entry, empty for the entry itself
+	Reason string
+}
+
+func (v shapeViolation) String() string {
+	if v.Path == "" {
+		return v.Reason
+	}
+	return fmt.Sprintf("%s: %s", v.Path, v.Reason)
+}
+
+// Checks an entry against a shape, listing every mismatch instead of a bool
+// Shapes that don't expose their definition can only be checked as a whole
+func checkShape(ctx base.Context, shape base.Shape, entry base.Entry) []shapeViolation {
+	if def, ok := shape.(base.Folder); ok {
+		return checkShapeDef(def, entry, "")
+	}
+
+	if !shape.Check(ctx, entry) {
+		return []shapeViolation{{"", "doesn't match shape " + shape.Name()}}
+	}
+	return nil
+}
+
+// Walks a shape definition folder, which looks like the ones in shapes.go
+func checkShapeDef(def base.Folder, entry base.Entry, at string) (violations []shapeViolation) {
+	typeName, _ := extras.GetChildString(def, "type")
+	if entry == nil {
+		return []shapeViolation{{at, "missing " + typeName}}
+	}
+	if !entryIsType(entry, typeName) {
+		reason := fmt.Sprintf("expected %s, got %s", typeName, render.TypeName(entry))
+		return []shapeViolation{{at, reason}}
+	}
+
+	folder, isFolder := entry.(base.Folder)
+	propsEntry, hasProps := def.Fetch("props")
+	props, propsOk := propsEntry.(base.Folder)
+	if !isFolder || !hasProps || !propsOk {
+		return nil
+	}
+
+	for _, name := range props.Children() {
+		propEntry, _ := props.Fetch(name)
+
+		var propDef base.Folder
+		switch prop := propEntry.(type) {
+		case base.String:
+			// shorthand for a required prop of that type
+			propDef = inmem.NewFolderOf(name, inmem.NewString("type", prop.Get()))
+		case base.Folder:
+			propDef = prop
+		default:
+			continue
+		}
+
+		child, ok := folder.Fetch(name)
+		if !ok {
+			if optional, _ := extras.GetChildString(propDef, "optional"); optional != "yes" {
+				propType, _ := extras.GetChildString(propDef, "type")
+				violations = append(violations, shapeViolation{path.Join(at, name), "missing " + propType})
+			}
+			continue
+		}
+		violations = append(violations, checkShapeDef(propDef, child, path.Join(at, name))...)
+	}
+	return
+}
+
+// Checks by interface rather than by name,
+// since some entries can act as more than one type
+func entryIsType(entry base.Entry, typeName string) (ok bool) {
+	switch typeName {
+	case "Folder":
+		_, ok = entry.(base.Folder)
+	case "String":
+		_, ok = entry.(base.String)
+	case "Function":
+		_, ok = entry.(base.Function)
+	case "Shape":
+		_, ok = entry.(base.Shape)
+	case "File":
+		_, ok = entry.(base.File)
+	case "Link":
+		_, ok = entry.(base.Link)
+	case "Log":
+		_, ok = entry.(base.Log)
+	case "Channel":
+		_, ok = entry.(base.Channel)
+	case "List":
+		_, ok = entry.(base.List)
+	default:
+		// unknown or unspecified types aren't ours to judge
+		ok = true
+	}
+	return
+}
@@ -11,6 +11,7 @@ func newShapesEntry() *inmem.Folder {
 		hueBridgeConfigShape,
 		webAppShape,
 		awsSqsReceiveMessageInputShape,
+		raySshOptsShape,
 	).Freeze()
 }
 
@@ -86,3 +87,34 @@ var webAppShape *inmem.Shape = inmem.NewShape(
 			),
 		),
 	))
+
+var raySshOptsShape *inmem.Shape = inmem.NewShape(
+	inmem.NewFolderOf("ray-ssh-opts",
+		inmem.NewString("type", "Folder"),
+		inmem.NewFolderOf("props",
+			inmem.NewFolderOf("shell-path",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+			inmem.NewFolderOf("history-path",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+			inmem.NewFolderOf("users-path",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+			inmem.NewFolderOf("listen-address",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+			inmem.NewFolderOf("host-key-path",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+			inmem.NewFolderOf("banner",
+				inmem.NewString("type", "String"),
+				inmem.NewString("optional", "yes"),
+			),
+		),
+	))
@@ -1,7 +1,7 @@
 package main
 
 import (
-	//"flag"
+	"flag"
 	//"fmt"
 	"log"
 
@@ -14,8 +14,19 @@ import (
 
 func main() {
 	//var port = flag.Int("port", 9234, "TCP port that the wormhole should be available on")
-	//var consulUri = flag.String("consul-uri", "http://127.0.0.1:8500", "Base URI for Consul (serves as nvram)")
-	//flag.Parse()
+	var bootConfig = flag.String("boot-config", "", "JSON or YAML file to present as /boot/cfg (defaults to the compiled-in ROM)")
+	var nvram = flag.String("nvram", "", "Storage driver to boot from, either consul or redis-ns (defaults to the compiled-in ROM)")
+	var consulUri = flag.String("consul-uri", "http://127.0.0.1:8500", "Base URI for Consul (serves as nvram)")
+	var redisAddress = flag.String("redis-address", "localhost:6379", "Address of the Redis server (serves as nvram)")
+	var nvramPath = flag.String("nvram-path", "", "Subtree of the nvram to bind as /boot/cfg (defaults to kv for consul, boot-cfg for redis-ns)")
+	flag.Parse()
+
+	if *bootConfig != "" && *nvram != "" {
+		log.Fatalln("Only one of -boot-config and -nvram can be used")
+	}
+	if *nvram != "" && !isNvramDriver(*nvram) {
+		log.Fatalf("Unknown -nvram driver %q, expected consul or redis-ns", *nvram)
+	}
 
 	//http.HandleFunc("/sockjs/info", ddp.ServeSockJsInfo)
 	//http.HandleFunc("/sockjs", ddp.ServeSockJs)
@@ -41,12 +52,26 @@ func main() {
 	// Mount the ROM driver at /n/rom
 	ctx.Put("/n/rom", romClone.Invoke(ctx, nil))
 
-	// Bind rom keyval tree to /boot/cfg
-	kv, ok := ctx.GetFolder("/n/rom/kv")
-	if !ok {
-		panic("ROM KV not found. That shouldn't happen.")
+	if *bootConfig != "" {
+		// Load the deployment's own config from disk
+		cfg, err := loadBootConfig(*bootConfig)
+		if err != nil {
+			log.Fatalln("Couldn't load boot config:", err)
+		}
+		ctx.Put("/boot/cfg", cfg)
+		log.Println("Loaded boot config from", *bootConfig)
+
+	} else if *nvram != "" {
+		// Clone the storage driver and bind its config subtree
+		input, subPath := nvramInput(*nvram, *consulUri, *redisAddress, *nvramPath)
+		if ok := bootFromNvram(ctx, *nvram, input, subPath); !ok {
+			log.Println("Couldn't boot from", *nvram, "- falling back to ROM config")
+			bindRomConfig(ctx)
+		}
+
+	} else {
+		bindRomConfig(ctx)
 	}
-	ctx.Put("/boot/cfg", kv)
 
 	// Get the init config
 	services, ok := ctx.GetFolder("/boot/cfg/services")
@@ -57,4 +82,5 @@ func main() {
 	// Run init
 	log.Println("Bootstrapped kernel. Handing control to initsys")
 	init.Invoke(ctx, services)
+	log.Println("Initsys exited, goodbye")
 }
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"encoding/base64"
+
+	"github.com/stardustapp/core/base"
+)
+
+// Describes an entry as a JSON-ready map
+// Folders list their children as maps too, down to MaxDepth
+// Strings always carry their value, since that's cheaper than another request
+func JSON(entry base.Entry, opts Options) map[string]interface{} {
+	return jsonEntry(entry, opts.MaxDepth, opts)
+}
+
+func jsonEntry(entry base.Entry, depth int, opts Options) map[string]interface{} {
+	if entry == nil {
+		return map[string]interface{}{
+			"type": "Unknown",
+		}
+	}
+
+	obj := map[string]interface{}{
+		"name": entry.Name(),
+		"type": TypeName(entry),
+	}
+
+	switch entry := entry.(type) {
+
+	case base.String:
+		obj["value"] = entry.Get()
+
+	case base.Link:
+		obj["target"] = entry.Target()
+
+	case base.File:
+		obj["size"] = entry.GetSize()
+		if data := readPreview(entry, opts.PreviewBytes); len(data) > 0 {
+			if isPrintable(data) {
+				obj["preview"] = string(data)
+			} else {
+				obj["preview"] = base64.StdEncoding.EncodeToString(data)
+				obj["preview-encoding"] = "base64"
+			}
+		}
+
+	case base.List:
+		obj["length"] = entry.Len()
+
+	case base.Folder:
+		if depth <= 0 {
+			break
+		}
+		names := entry.Children()
+		children := make([]map[string]interface{}, len(names))
+		for idx, name := range names {
+			child, ok := entry.Fetch(name)
+			if !ok {
+				children[idx] = map[string]interface{}{
+					"name": name,
+					"type": "Unknown",
+				}
+				continue
+			}
+			children[idx] = jsonEntry(child, depth-1, opts)
+			children[idx]["name"] = name
+		}
+		obj["children"] = children
+	}
+	return obj
+}
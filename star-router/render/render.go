@@ -0,0 +1,85 @@
+// Package render formats any namespace entry for people or for clients,
+// so that every frontend describes entries the same way
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/stardustapp/core/base"
+)
+
+// Controls how much of an entry gets rendered
+type Options struct {
+	// How many levels of folders to list below the entry
+	// Zero renders only the entry itself
+	MaxDepth int
+
+	// How many bytes of a File to show
+	PreviewBytes int
+}
+
+// Reasonable limits for a terminal
+var DefaultOptions = Options{
+	MaxDepth:     2,
+	PreviewBytes: 256,
+}
+
+// Names the kind of entry, as used in shape definitions
+func TypeName(entry base.Entry) string {
+	switch entry.(type) {
+	case base.Function:
+		return "Function"
+	case base.Shape:
+		return "Shape"
+	case base.String:
+		return "String"
+	case base.File:
+		return "File"
+	case base.Link:
+		return "Link"
+	case base.Log:
+		return "Log"
+	case base.Channel:
+		return "Channel"
+	case base.List:
+		return "List"
+	case base.Folder:
+		return "Folder"
+	default:
+		return "Unknown"
+	}
+}
+
+// One-line summary of an entry, without its name
+func Summary(entry base.Entry) string {
+	switch entry := entry.(type) {
+	case base.String:
+		if txt, err := json.Marshal(entry.Get()); err == nil {
+			return fmt.Sprintf("string %v", string(txt))
+		}
+		return "string"
+
+	case base.Folder:
+		return fmt.Sprintf("folder (%d children)", len(entry.Children()))
+
+	case base.Link:
+		return fmt.Sprintf("link -> %s", entry.Target())
+	case base.Log:
+		return "log"
+	case base.Channel:
+		return "queue"
+	case base.Function:
+		return "function"
+	case base.Shape:
+		return "shape"
+	case base.List:
+		return fmt.Sprintf("list (%d items)", entry.Len())
+	case base.File:
+		return fmt.Sprintf("file (%d bytes)", entry.GetSize())
+	case nil:
+		return "missing"
+	default:
+		return "unknown"
+	}
+}
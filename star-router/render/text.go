@@ -0,0 +1,124 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/stardustapp/core/base"
+)
+
+const indentUnit = "  "
+
+// Formats an entry as indented lines of text
+func Text(entry base.Entry, opts Options) []string {
+	var lines []string
+	writeText(&lines, entry, "", opts.MaxDepth, opts)
+	return lines
+}
+
+func writeText(lines *[]string, entry base.Entry, indent string, depth int, opts Options) {
+	if entry == nil {
+		*lines = append(*lines, indent+"(missing)")
+		return
+	}
+	name := entry.Name()
+
+	switch entry := entry.(type) {
+
+	case base.String:
+		value := entry.Get()
+		if !strings.Contains(value, "\n") {
+			*lines = append(*lines, fmt.Sprintf("%s%s: %s", indent, name, value))
+			return
+		}
+		*lines = append(*lines, fmt.Sprintf("%s%s:", indent, name))
+		for _, line := range strings.Split(value, "\n") {
+			*lines = append(*lines, indent+indentUnit+line)
+		}
+
+	case base.Link:
+		*lines = append(*lines, fmt.Sprintf("%s%s -> %s", indent, name, entry.Target()))
+
+	case base.File:
+		size := entry.GetSize()
+		*lines = append(*lines, fmt.Sprintf("%s%s: file, %d bytes", indent, name, size))
+		for _, line := range previewLines(entry, opts.PreviewBytes) {
+			*lines = append(*lines, indent+indentUnit+line)
+		}
+
+	case base.Folder:
+		names := entry.Children()
+		if depth <= 0 {
+			*lines = append(*lines, fmt.Sprintf("%s%s/ (%d children)", indent, name, len(names)))
+			return
+		}
+		*lines = append(*lines, fmt.Sprintf("%s%s/", indent, name))
+		for _, childName := range names {
+			child, ok := entry.Fetch(childName)
+			if !ok {
+				*lines = append(*lines, fmt.Sprintf("%s%s%s: (missing)", indent, indentUnit, childName))
+				continue
+			}
+			writeText(lines, child, indent+indentUnit, depth-1, opts)
+		}
+
+	default:
+		*lines = append(*lines, fmt.Sprintf("%s%s: %s", indent, name, Summary(entry)))
+	}
+}
+
+// Shows the start of a file as text if it looks like text,
+// or as hex otherwise, so binary data can't garble a terminal
+func previewLines(file base.File, limit int) []string {
+	data := readPreview(file, limit)
+	if len(data) == 0 {
+		return nil
+	}
+
+	var lines []string
+	if isPrintable(data) {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else {
+		for offset := 0; offset < len(data); offset += 16 {
+			end := offset + 16
+			if end > len(data) {
+				end = len(data)
+			}
+			lines = append(lines, fmt.Sprintf("%08x  % x", offset, data[offset:end]))
+		}
+	}
+
+	if int64(len(data)) < file.GetSize() {
+		lines = append(lines, "...")
+	}
+	return lines
+}
+
+func readPreview(file base.File, limit int) []byte {
+	size := file.GetSize()
+	if size <= 0 || limit <= 0 {
+		return nil
+	}
+	if size > int64(limit) {
+		size = int64(limit)
+	}
+	return file.Read(0, int(size))
+}
+
+// Whether bytes are valid UTF-8 without control characters,
+// allowing for a multibyte character cut off at the end
+func isPrintable(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			return !utf8.FullRune(data)
+		}
+		if unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}